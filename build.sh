@@ -6,3 +6,9 @@ cd ../../
 cd cmd/scramjet
 go build
 cd ../../
+cd cmd/exporter
+go build
+cd ../../
+cd cmd/orphans
+go build
+cd ../../
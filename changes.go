@@ -0,0 +1,42 @@
+package scramjet
+
+type ChangeOp string
+
+const (
+	AddOp    ChangeOp = "add"
+	UpdateOp ChangeOp = "update"
+	DeleteOp ChangeOp = "delete"
+)
+
+// what happened to a resource - figured out from the hash comparison
+// when moving from staging (or from the row removed for deletes)
+// NOTE: for deletes Hash and Data are empty, PreviousHash and
+// PreviousData are what was removed
+type Change struct {
+	Id           Identifier
+	Op           ChangeOp
+	Hash         string
+	PreviousHash string
+	Data         []byte
+	PreviousData []byte
+	// only for updates with Config.TrackPatches - PreviousData to Data
+	Patch JSONPatch
+}
+
+func (c Change) Identifier() Identifier {
+	return c.Id
+}
+
+// keeps the order changes came in, per type
+func groupChangesByType(changes []Change) ([]string, map[string][]Change) {
+	typeNames := []string{}
+	grouped := make(map[string][]Change)
+	for _, change := range changes {
+		typeName := change.Id.Type
+		if _, ok := grouped[typeName]; !ok {
+			typeNames = append(typeNames, typeName)
+		}
+		grouped[typeName] = append(grouped[typeName], change)
+	}
+	return typeNames, grouped
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+	"github.com/namsral/flag"
+)
+
+// e.g. COLUMNS="name=$.name,city=$.address.city"
+func parseColumns(spec string) []sj.ExportColumn {
+	columns := []sj.ExportColumn{}
+	if len(spec) == 0 {
+		return columns
+	}
+	for _, part := range strings.Split(spec, ",") {
+		pieces := strings.SplitN(part, "=", 2)
+		name := strings.TrimSpace(pieces[0])
+		path := name
+		if len(pieces) == 2 {
+			path = strings.TrimSpace(pieces[1])
+		}
+		columns = append(columns, sj.ExportColumn{Name: name, Path: path})
+	}
+	return columns
+}
+
+// e.g. FILTER="name=Test1" or "year>=2020" (one field of the data)
+func parseFilter(spec string) (*sj.Filter, error) {
+	at := strings.IndexAny(spec, "<>=")
+	if at <= 0 {
+		return nil, fmt.Errorf("could not parse filter %q (should be field=value)", spec)
+	}
+	compare := spec[at : at+1]
+	if compare != "=" && strings.HasPrefix(spec[at+1:], "=") {
+		compare += "="
+	}
+	return &sj.Filter{
+		Field:   strings.TrimSpace(spec[:at]),
+		Value:   strings.TrimSpace(spec[at+len(compare):]),
+		Compare: sj.CompareOpt(compare),
+	}, nil
+}
+
+func main() {
+	var conf sj.Config
+
+	dbServer := flag.String("DB_SERVER", "", "database server")
+	dbPort := flag.Int("DB_PORT", 0, "database port")
+	dbDatabase := flag.String("DB_DATABASE", "", "database database")
+	dbUser := flag.String("DB_USER", "", "database user")
+	dbPassword := flag.String("DB_PASSWORD", "", "database password")
+	dbMaxConnections := flag.Int("DB_MAX_CONNECTIONS", 1, "database maximum pool conections")
+	dbAquireTimeout := flag.Int("DB_ACQUIRE_TIMEOUT", 30, "how many seconds to wait to get connection")
+
+	typeName := flag.String("TYPE", "", "resource type to export")
+	format := flag.String("FORMAT", "ndjson", "ndjson, json or csv")
+	since := flag.String("SINCE", "", "only resources updated after this (RFC3339)")
+	filter := flag.String("FILTER", "", "only resources matching field=value (or <, >, <=, >=)")
+	columns := flag.String("COLUMNS", "", "csv columns as name=path,name=path")
+	file := flag.String("FILE", "", "file to write (or read with IMPORT) - default stdout/stdin")
+	doImport := flag.Bool("IMPORT", false, "read a snapshot into staging instead of exporting")
+
+	flag.Parse()
+
+	if len(*dbServer) == 0 && len(*dbUser) == 0 {
+		log.Fatal("database credentials need to be set")
+	} else {
+		database := sj.DatabaseInfo{
+			Server:         *dbServer,
+			Database:       *dbDatabase,
+			Password:       *dbPassword,
+			Port:           *dbPort,
+			User:           *dbUser,
+			MaxConnections: *dbMaxConnections,
+			AcquireTimeout: *dbAquireTimeout,
+			Application:    "scramjet-exporter",
+		}
+		conf = sj.Config{
+			Database: database,
+		}
+	}
+
+	exportFormat, err := sj.ParseExportFormat(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// NOTE: this will log.Fatal if it can't connect (and makes the tables)
+	sj.Configure(conf)
+	defer sj.Shutdown()
+
+	if *doImport {
+		var in io.Reader = os.Stdin
+		if len(*file) > 0 {
+			f, err := os.Open(*file)
+			if err != nil {
+				log.Fatalf("could not open %s: %v", *file, err)
+			}
+			defer f.Close()
+			in = f
+		}
+		// NOTE: csv needs the COLUMNS it was exported with
+		count, err := sj.ImportSnapshot(in, exportFormat, parseColumns(*columns)...)
+		if err != nil {
+			log.Fatalf("import failed after %d records: %v", count, err)
+		}
+		log.Printf("staged %d records\n", count)
+		return
+	}
+
+	if len(*typeName) == 0 {
+		log.Fatal("TYPE needs to be set")
+	}
+	config := sj.ExportConfig{
+		TypeName: *typeName,
+		Format:   exportFormat,
+		Columns:  parseColumns(*columns),
+	}
+	if len(*since) > 0 {
+		stamp, err := time.Parse(time.RFC3339, *since)
+		if err != nil {
+			log.Fatalf("could not parse SINCE: %v", err)
+		}
+		config.Since = &stamp
+	}
+	if len(*filter) > 0 {
+		config.Filter, err = parseFilter(*filter)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if len(*file) > 0 {
+		f, err := os.Create(*file)
+		if err != nil {
+			log.Fatalf("could not create %s: %v", *file, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	count, err := sj.ExportResources(out, config)
+	if err != nil {
+		log.Fatalf("export failed after %d records: %v", count, err)
+	}
+	log.Printf("exported %d %s records\n", count, *typeName)
+}
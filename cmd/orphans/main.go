@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+	"github.com/namsral/flag"
+)
+
+// e.g. LINKS="education.personId=person,publication.authorIds[]=person"
+// (child type, then the field, [] if it's a list of parent ids)
+func parseLinks(spec string) ([]sj.Relationship, error) {
+	relationships := []sj.Relationship{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		pieces := strings.SplitN(part, "=", 2)
+		child := strings.SplitN(strings.TrimSpace(pieces[0]), ".", 2)
+		if len(pieces) != 2 || len(child) != 2 {
+			return relationships, fmt.Errorf("could not parse link %q (should be child.field=parent)", part)
+		}
+		relationship := sj.Relationship{
+			ChildType:  child[0],
+			Field:      child[1],
+			ParentType: strings.TrimSpace(pieces[1]),
+		}
+		if strings.HasSuffix(relationship.Field, "[]") {
+			relationship.Field = strings.TrimSuffix(relationship.Field, "[]")
+			relationship.Cardinality = sj.ManyToMany
+		}
+		relationships = append(relationships, relationship)
+	}
+	return relationships, nil
+}
+
+func main() {
+	var conf sj.Config
+
+	dbServer := flag.String("DB_SERVER", "", "database server")
+	dbPort := flag.Int("DB_PORT", 0, "database port")
+	dbDatabase := flag.String("DB_DATABASE", "", "database database")
+	dbUser := flag.String("DB_USER", "", "database user")
+	dbPassword := flag.String("DB_PASSWORD", "", "database password")
+	dbMaxConnections := flag.Int("DB_MAX_CONNECTIONS", 1, "database maximum pool conections")
+	dbAquireTimeout := flag.Int("DB_ACQUIRE_TIMEOUT", 30, "how many seconds to wait to get connection")
+
+	links := flag.String("LINKS", "", "child.field=parent links, comma separated (field[] for a list)")
+	flagDeletes := flag.Bool("FLAG", false, "flag the orphans for delete in staging")
+
+	flag.Parse()
+
+	if len(*dbServer) == 0 && len(*dbUser) == 0 {
+		log.Fatal("database credentials need to be set")
+	} else {
+		database := sj.DatabaseInfo{
+			Server:         *dbServer,
+			Database:       *dbDatabase,
+			Password:       *dbPassword,
+			Port:           *dbPort,
+			User:           *dbUser,
+			MaxConnections: *dbMaxConnections,
+			AcquireTimeout: *dbAquireTimeout,
+			Application:    "scramjet-orphans",
+		}
+		conf = sj.Config{
+			Database: database,
+		}
+	}
+
+	relationships, err := parseLinks(*links)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(relationships) == 0 {
+		log.Fatal("LINKS needs to be set")
+	}
+
+	if err := sj.MakeConnectionPool(conf); err != nil {
+		fmt.Printf("could not establish postgresql connection %s\n", err)
+		os.Exit(1)
+	}
+	defer sj.DBPool.Close()
+
+	orphans, err := sj.FindOrphans(relationships...)
+	if err != nil {
+		log.Fatalf("could not find orphans: %v", err)
+	}
+	for _, orphan := range orphans {
+		fmt.Printf("%s %s: %s %s (%s) not found\n", orphan.Id.Type, orphan.Id.Id,
+			orphan.ParentType, orphan.ParentId, orphan.Field)
+	}
+	log.Printf("found %d orphans\n", len(orphans))
+
+	if *flagDeletes {
+		err = sj.FlagOrphansForDelete(orphans...)
+		if err != nil {
+			log.Fatalf("could not flag orphans for delete: %v", err)
+		}
+		log.Printf("flagged %d orphans for delete\n", len(orphans))
+	}
+}
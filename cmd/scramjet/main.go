@@ -18,6 +18,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	sj "github.com/OIT-ADS-Web/scramjet"
@@ -92,35 +93,179 @@ Nozzle
 
 --> Kinetic
 
-TODO: haven't used this yet - probably doesn't work
 */
+
+// a json array of records of the category - ids come from the category's
+// IdExtractor (see ID_PATHS), records without one are listed back
+//
+//	curl --header "Content-Type: application/json" \
+//	  --request POST \
+//	  --data '[{"uri": "per0000001", "name": "Test1"}]' \
+//	  http://localhost:8855/intake/person
 func IntakeHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
 
-	io.WriteString(w, fmt.Sprintf(`{"category": %s}`, vars["category"]))
-
-	var arr []sj.Packet
+	var arr []json.RawMessage
 	receivedJSON, err := ioutil.ReadAll(r.Body) //This reads raw request body
-	if err != nil {
-		panic(err)
+	if err == nil {
+		err = json.Unmarshal(receivedJSON, &arr)
 	}
-	err = json.Unmarshal([]byte(receivedJSON), &arr)
 	if err != nil {
-		panic(err)
+		w.WriteHeader(http.StatusBadRequest)
+		message, _ := json.Marshal(err.Error())
+		io.WriteString(w, fmt.Sprintf(`{"error": %s}`, message))
+		return
 	}
 
 	resources := []sj.Storeable{}
-	for _, res := range arr {
-		res := sj.Packet{Id: sj.Identifier{Id: res.Identifier().Id, Type: vars["category"]},
-			Obj: res}
-		resources = append(resources, res)
+	for _, raw := range arr {
+		resources = append(resources, sj.MakeRawJSONPacket(vars["category"], raw))
+	}
+	result, err := sj.StageTransformed(resources...)
+	if _, ok := err.(sj.MissingIdError); ok {
+		// NOTE: the rest were staged
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		body, _ := json.Marshal(stageResponse(result))
+		w.Write(body)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		message, _ := json.Marshal(err.Error())
+		io.WriteString(w, fmt.Sprintf(`{"error": %s}`, message))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	body, _ := json.Marshal(stageResponse(result))
+	w.Write(body)
+}
+
+// only what was really staged is in "staged" - the ids of the ones that
+// failed a transform are in "intake_errors" ("" if it had no id yet)
+func stageResponse(result sj.StageResult) map[string]interface{} {
+	failed := []string{}
+	for _, intakeError := range result.Failed {
+		failed = append(failed, intakeError.Id.Id)
 	}
-	err = sj.BulkAddStaging(resources...)
+	response := map[string]interface{}{
+		"staged":        result.Staged,
+		"dropped":       result.Dropped,
+		"intake_errors": failed,
+	}
+	if len(result.Missing) > 0 {
+		response["missing"] = result.Missing
+	}
+	return response
+}
+
+// e.g. ID_PATHS="person=$.uri,grant=personId+year" (+ for a composite id)
+func parseIdPaths(spec string) (map[string]sj.IdExtractor, error) {
+	extractors := make(map[string]sj.IdExtractor)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		pieces := strings.SplitN(part, "=", 2)
+		if len(pieces) != 2 {
+			return extractors, fmt.Errorf("could not parse id path %q (should be type=path)", part)
+		}
+		path := strings.TrimSpace(pieces[1])
+		if strings.Contains(path, "+") {
+			extractors[strings.TrimSpace(pieces[0])] = sj.IdExtractor{Fields: strings.Split(path, "+")}
+		} else {
+			extractors[strings.TrimSpace(pieces[0])] = sj.IdExtractor{Path: path}
+		}
+	}
+	return extractors, nil
+}
+
+// partial updates - applied to what is staged (or in resources) for the id
+//
+//	curl --header "Content-Type: application/merge-patch+json" \
+//	  --request PATCH \
+//	  --data '{"name": "Robb"}' \
+//	  http://localhost:8855/intake/person/0000001
+//
+// or "Content-Type: application/json-patch+json" with
+// [{"op": "replace", "path": "/name", "value": "Robb"}]
+func PatchHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	w.Header().Set("Content-Type", "application/json")
+
+	kind := sj.MergePatchKind
+	if r.Header.Get("Content-Type") == "application/json-patch+json" {
+		kind = sj.JSONPatchKind
+	}
+	patch, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		panic(err)
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error": "could not read body"}`)
+		return
+	}
+	id := sj.Identifier{Id: vars["id"], Type: vars["category"]}
+	err = sj.PatchStaging(id, kind, patch)
+	if _, ok := err.(sj.NoBaseRecordError); ok {
+		w.WriteHeader(http.StatusNotFound)
+	} else if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	} else {
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, `{"patched": true}`)
+		return
 	}
+	message, _ := json.Marshal(err.Error())
+	io.WriteString(w, fmt.Sprintf(`{"error": %s}`, message))
+}
+
+// the full list of ids the source has for the category - anything else
+// is flagged for delete, unless it's over the DELETE_MAX_* threshold
+//
+//	curl --request POST \
+//	  --header "X-Override-Token: <token from the blocked response>" \
+//	  --data '["per0000001", "per0000002"]' \
+//	  http://localhost:8855/outake/person
+func OutakeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	w.Header().Set("Content-Type", "application/json")
+
+	var ids []string
+	receivedJSON, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(receivedJSON, &ids)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		message, _ := json.Marshal(err.Error())
+		io.WriteString(w, fmt.Sprintf(`{"error": %s}`, message))
+		return
+	}
+	out := sj.OutakeConfig{
+		TypeName:      vars["category"],
+		ListMaker:     func() ([]string, error) { return ids, nil },
+		DryRun:        r.URL.Query().Get("dryRun") == "true",
+		OverrideToken: r.Header.Get("X-Override-Token"),
+	}
+	summary, err := sj.ProcessOutake(out)
+	if blocked, ok := err.(sj.BlockedDeletesError); ok {
+		// NOTE: the report - nothing was flagged
+		w.WriteHeader(http.StatusConflict)
+		body, _ := json.Marshal(map[string]interface{}{
+			"error":    blocked.Error(),
+			"existing": blocked.Existing,
+			"blocked":  blocked.Deletes,
+			"token":    blocked.Token,
+		})
+		w.Write(body)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		message, _ := json.Marshal(err.Error())
+		io.WriteString(w, fmt.Sprintf(`{"error": %s}`, message))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, fmt.Sprintf(`{"flagged": %d}`, summary.Deleted))
 }
 
 func TransferHandler(w http.ResponseWriter, r *http.Request) {
@@ -166,6 +311,9 @@ func main() {
 	//wait := flag.Int("graceful-timeout", time.Second * 15,
 	//"the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
 	wait := time.Second * 15 // FIXME: make this configurable?
+	idPaths := flag.String("ID_PATHS", "", "type=path id extractors for intake, comma separated")
+	deleteMaxCount := flag.Int("DELETE_MAX_COUNT", 0, "most deletes an outake can flag for a type (0 is no limit)")
+	deleteMaxPercent := flag.Float64("DELETE_MAX_PERCENT", 0, "most deletes an outake can flag, as a percent of the type")
 
 	flag.Parse()
 
@@ -187,18 +335,16 @@ func main() {
 		}
 	}
 
-	if err := sj.MakeConnectionPool(conf); err != nil {
-		fmt.Printf("could not establish postgresql connection %s\n", err)
-		os.Exit(1)
-	}
+	conf.DeleteThreshold = sj.DeleteThreshold{MaxCount: *deleteMaxCount, MaxPercent: *deleteMaxPercent}
+	// NOTE: makes the pool, and any tables that aren't there
+	sj.Configure(conf)
 
-	if !sj.StagingTableExists() {
-		fmt.Println("staging table not found")
-		sj.MakeStagingSchema()
+	extractors, err := parseIdPaths(*idPaths)
+	if err != nil {
+		log.Fatal(err)
 	}
-	if !sj.ResourceTableExists() {
-		fmt.Println("resources table not found")
-		sj.MakeResourceSchema()
+	for typeName, extractor := range extractors {
+		sj.RegisterIdExtractor(typeName, extractor)
 	}
 
 	// server goes here ...
@@ -227,8 +373,9 @@ func main() {
 
 		       id param?
 	*/
-	router.HandleFunc("/intake", IntakeHandler).Methods("POST")
-	//router.HandleFunc("/intake/{category}", IntakeHandler).Methods("POST")
+	router.HandleFunc("/intake/{category}", IntakeHandler).Methods("POST")
+	router.HandleFunc("/intake/{category}/{id}", PatchHandler).Methods("PATCH")
+	router.HandleFunc("/outake/{category}", OutakeHandler).Methods("POST")
 	router.HandleFunc("/transfer/{category}", TransferHandler).Methods("POST")
 	router.HandleFunc("/transfer/{category}/{id:[0-9]+}", TransferHandler).Methods("POST")
 	router.HandleFunc("/launch/{category}", LaunchHandler).Methods("GET")
@@ -1,15 +1,81 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	sj "github.com/OIT-ADS-Web/scramjet"
 	"github.com/namsral/flag"
 )
 
-// make this a server?
+// one json record per line - ids come from ID_PATH (or ID_FIELDS),
+// otherwise they have to be there already (see sj.RegisterIdExtractor)
+//
+// (the counts are for the whole file, Missing indexes are line numbers)
+//
+//	TYPE=person FILE=people.ndjson ID_PATH='$.uri' staging_importer
+func importFile(typeName string, fileName string, batchSize int) (sj.StageResult, error) {
+	total := sj.StageResult{Failed: []sj.IntakeError{}, Missing: []sj.MissingId{}}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return total, err
+	}
+	defer file.Close()
+
+	// line number (blank ones count too) of each record in batch
+	lines := []int{}
+	batch := []sj.Storeable{}
+	stage := func() error {
+		result, err := sj.StageTransformed(batch...)
+		if _, ok := err.(sj.MissingIdError); ok {
+			for _, record := range result.Missing {
+				record.Index = lines[record.Index]
+				total.Missing = append(total.Missing, record)
+			}
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		total.Staged += result.Staged
+		total.Dropped += result.Dropped
+		total.Failed = append(total.Failed, result.Failed...)
+		lines = []int{}
+		batch = []sj.Storeable{}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		lines = append(lines, lineNumber)
+		batch = append(batch, sj.MakeRawJSONPacket(typeName, []byte(line)))
+		if len(batch) >= batchSize {
+			if err := stage(); err != nil {
+				return total, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return total, err
+	}
+	if len(batch) > 0 {
+		if err := stage(); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
 func main() {
 	var conf sj.Config
 
@@ -21,6 +87,13 @@ func main() {
 	dbMaxConnections := flag.Int("DB_MAX_CONNECTIONS", 1, "database maximum pool conections")
 	dbAquireTimeout := flag.Int("DB_ACQUIRE_TIMEOUT", 30, "how many seconds to wait to get connection")
 
+	typeName := flag.String("TYPE", "", "type of the records")
+	fileName := flag.String("FILE", "", "file with one json record per line")
+	idPath := flag.String("ID_PATH", "", "json path to the id e.g. $.uri")
+	idFields := flag.String("ID_FIELDS", "", "fields that make up the id, comma separated")
+	separator := flag.String("SEPARATOR", "|", "between the ID_FIELDS values")
+	batchSize := flag.Int("BATCH_SIZE", 500, "how many records to stage at a time")
+
 	flag.Parse()
 
 	if len(*dbServer) == 0 && len(*dbUser) == 0 {
@@ -34,26 +107,34 @@ func main() {
 			User:           *dbUser,
 			MaxConnections: *dbMaxConnections,
 			AcquireTimeout: *dbAquireTimeout,
-			Application:    "scramjet",
+			Application:    "scramjet-importer",
 		}
 		conf = sj.Config{
 			Database: database,
 		}
 	}
 
-	if err := sj.MakeConnectionPool(conf); err != nil {
-		fmt.Printf("could not establish postgresql connection %s\n", err)
-		os.Exit(1)
-	}
+	// NOTE: this will log.Fatal if it can't connect - and makes the
+	// tables (intake_errors too, for transforms) and the logger
+	sj.Configure(conf)
+	defer sj.Shutdown()
 
-	if !sj.StagingTableExists() {
-		fmt.Println("staging table not found")
-		sj.MakeStagingSchema()
+	if len(*fileName) == 0 || len(*typeName) == 0 {
+		return
 	}
-	if !sj.ResourceTableExists() {
-		fmt.Println("resources table not found")
-		sj.MakeResourceSchema()
+	if len(*idPath) > 0 {
+		sj.RegisterIdExtractor(*typeName, sj.IdExtractor{Path: *idPath})
+	} else if len(*idFields) > 0 {
+		sj.RegisterIdExtractor(*typeName, sj.IdExtractor{Fields: strings.Split(*idFields, ","),
+			Separator: *separator})
 	}
-
-	defer sj.DBPool.Close()
+	result, err := importFile(*typeName, *fileName, *batchSize)
+	for _, record := range result.Missing {
+		fmt.Printf("line %d: %s\n", record.Index, record.Reason)
+	}
+	if err != nil {
+		log.Fatalf("could not import %s: %v", *fileName, err)
+	}
+	log.Printf("staged %d %s records (%d with no id, %d dropped, %d in intake_errors)\n",
+		result.Staged, *typeName, len(result.Missing), result.Dropped, len(result.Failed))
 }
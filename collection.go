@@ -0,0 +1,245 @@
+package scramjet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// a type name bound to a go struct, e.g.
+//
+//	people := sj.RegisterCollection("person",
+//	  func(p Person) string { return p.Id },
+//	  func(p Person) bool { return len(p.Name) > 0 })
+//
+// so there's no MakePacket and no json on the way in or out (the id
+// function is registered as the type's IdExtractor too)
+type Collection[T any] struct {
+	TypeName string
+	Id       func(T) string
+	// optional - used whenever the type is validated without a validator
+	// (TransferAll(typeName, nil) etc...), with or without it the json has
+	// to unmarshal into T
+	Validate func(T) bool
+}
+
+type registeredCollection struct {
+	collection interface{} // *Collection[T]
+	validator  ValidatorFunc
+}
+
+var collectionMutex sync.RWMutex
+var collections = make(map[string]registeredCollection)
+
+// NOTE: one per type name - registering again replaces it
+func RegisterCollection[T any](typeName string, id func(T) string, validate func(T) bool) *Collection[T] {
+	c := &Collection[T]{TypeName: typeName, Id: id, Validate: validate}
+	validator := c.validator()
+	// NOTE: so raw json of the type can be staged too (see RawPacket)
+	RegisterIdExtractor(typeName, IdExtractor{Func: func(data []byte) (string, error) {
+		var item T
+		err := json.Unmarshal(data, &item)
+		if err != nil {
+			return "", err
+		}
+		return c.Id(item), nil
+	}})
+	collectionMutex.Lock()
+	defer collectionMutex.Unlock()
+	collections[typeName] = registeredCollection{collection: c, validator: validator}
+	return c
+}
+
+// NOTE: the id extractors they registered go too
+func ClearCollections() {
+	collectionMutex.Lock()
+	defer collectionMutex.Unlock()
+	for typeName := range collections {
+		removeIdExtractor(typeName)
+	}
+	collections = make(map[string]registeredCollection)
+}
+
+// the registered collection for a type name, if it was registered with T
+func GetCollection[T any](typeName string) (*Collection[T], bool) {
+	collectionMutex.RLock()
+	defer collectionMutex.RUnlock()
+	registered, ok := collections[typeName]
+	if !ok {
+		return nil, false
+	}
+	c, ok := registered.collection.(*Collection[T])
+	return c, ok
+}
+
+// validator if there is one, otherwise the registered collection's
+func registeredValidator(typeName string, validator ValidatorFunc) ValidatorFunc {
+	if validator != nil {
+		return validator
+	}
+	collectionMutex.RLock()
+	defer collectionMutex.RUnlock()
+	return collections[typeName].validator
+}
+
+// NOTE: json that doesn't unmarshal into T is not valid
+func (c *Collection[T]) validator() ValidatorFunc {
+	return func(text string) bool {
+		var item T
+		err := json.Unmarshal([]byte(text), &item)
+		if err != nil {
+			return false
+		}
+		if c.Validate == nil {
+			return true
+		}
+		return c.Validate(item)
+	}
+}
+
+func (c *Collection[T]) packet(item T) (Packet, error) {
+	id := c.Id(item)
+	if len(id) == 0 {
+		return Packet{}, errors.New(fmt.Sprintf("%s with no id: %+v", c.TypeName, item))
+	}
+	return MakePacket(id, c.TypeName, item), nil
+}
+
+func (c *Collection[T]) Stage(items ...T) error {
+	packets := []Storeable{}
+	for _, item := range items {
+		packet, err := c.packet(item)
+		if err != nil {
+			return err
+		}
+		packets = append(packets, packet)
+	}
+	return BulkAddStaging(packets...)
+}
+
+func (c *Collection[T]) Get(id string) (T, error) {
+	var item T
+	res, err := RetrieveSingleResource(id, c.TypeName)
+	if err != nil {
+		return item, err
+	}
+	err = json.Unmarshal(res.Data.Bytes, &item)
+	if err != nil {
+		return item, errors.Wrap(err, fmt.Sprintf("unmarshalling %s %s", c.TypeName, id))
+	}
+	return item, nil
+}
+
+func (c *Collection[T]) List() ([]T, error) {
+	resources, err := RetrieveTypeResources(c.TypeName)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalResources[T](resources)
+}
+
+func (c *Collection[T]) Filter(filter Filter) ([]T, error) {
+	resources, err := RetrieveTypeResourcesByQuery(c.TypeName, filter)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalResources[T](resources)
+}
+
+// added or updated after since
+func (c *Collection[T]) Since(since time.Time) ([]T, error) {
+	db := GetPool()
+	ctx := context.Background()
+	sql := `SELECT id, type, hash, data, data_b
+	  FROM resources
+	  WHERE type = $1
+	  AND updated_at > $2
+	  ORDER BY updated_at`
+	rows, err := db.Query(ctx, sql, c.TypeName, since)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := ScanResources(rows)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalResources[T](resources)
+}
+
+func unmarshalResources[T any](resources []Resource) ([]T, error) {
+	items := make([]T, 0, len(resources))
+	for _, res := range resources {
+		var item T
+		err := json.Unmarshal(res.Data.Bytes, &item)
+		if err != nil {
+			return items, errors.Wrap(err, fmt.Sprintf("unmarshalling %s %s", res.Type, res.Id))
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// a ChangeBatch with the data already unmarshalled (deletes are what
+// was in resources before)
+type CollectionBatch[T any] struct {
+	TypeName string
+	Adds     []T
+	Updates  []T
+	Deletes  []T
+}
+
+type collectionSink[T any] struct {
+	name string
+	send func(CollectionBatch[T]) error
+}
+
+func (s collectionSink[T]) Name() string {
+	return s.name
+}
+
+func (s collectionSink[T]) Send(batch ChangeBatch) error {
+	typed := CollectionBatch[T]{TypeName: batch.TypeName}
+	var err error
+	typed.Adds, err = unmarshalChanges[T](batch.Adds, false)
+	if err != nil {
+		return err
+	}
+	typed.Updates, err = unmarshalChanges[T](batch.Updates, false)
+	if err != nil {
+		return err
+	}
+	typed.Deletes, err = unmarshalChanges[T](batch.Deletes, true)
+	if err != nil {
+		return err
+	}
+	return s.send(typed)
+}
+
+func unmarshalChanges[T any](changes []Change, previous bool) ([]T, error) {
+	items := make([]T, 0, len(changes))
+	for _, change := range changes {
+		data := change.Data
+		if previous {
+			data = change.PreviousData
+		}
+		if len(data) == 0 {
+			continue
+		}
+		var item T
+		err := json.Unmarshal(data, &item)
+		if err != nil {
+			return items, errors.Wrap(err, fmt.Sprintf("unmarshalling %s", change.Id))
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// change feed - registers a sink (see RegisterSink) for just this type
+func (c *Collection[T]) OnChange(name string, send func(CollectionBatch[T]) error) {
+	RegisterSink(collectionSink[T]{name: name, send: send}, c.TypeName)
+}
@@ -0,0 +1,97 @@
+package scramjet_test
+
+import (
+	"testing"
+	"time"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+func TestCollection(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	sj.ClearSinks()
+	defer sj.ClearSinks()
+	defer sj.ClearCollections()
+
+	people := sj.RegisterCollection("person",
+		func(p TestPerson) string { return p.Id },
+		func(p TestPerson) bool { return len(p.Name) > 0 })
+	adds := []TestPerson{}
+	people.OnChange("recorder", func(batch sj.CollectionBatch[TestPerson]) error {
+		adds = append(adds, batch.Adds...)
+		return nil
+	})
+
+	if found, ok := sj.GetCollection[TestPerson]("person"); !ok || found != people {
+		t.Error("should find the registered collection\n")
+	}
+	if _, ok := sj.GetCollection[TestPublication]("person"); ok {
+		t.Error("should not find the collection for a different struct\n")
+	}
+
+	start := time.Now().Add(-time.Second)
+	err := people.Stage(TestPerson{Id: "per0000001", Name: "Test1"},
+		TestPerson{Id: "per0000002", Name: ""})
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	// NOTE: no validator - the collection's is used
+	summary, err := sj.TransferAll("person", nil)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Inserted != 1 {
+		t.Errorf("only per0000001 is valid - not %s\n", summary)
+	}
+	if len(adds) != 1 || adds[0].Name != "Test1" {
+		t.Errorf("change feed should have per0000001 - not %v\n", adds)
+	}
+
+	person, err := people.Get("per0000001")
+	if err != nil || person.Name != "Test1" {
+		t.Errorf("should get per0000001 - not %v (err=%v)\n", person, err)
+	}
+	list, err := people.List()
+	if err != nil || len(list) != 1 {
+		t.Errorf("should list 1 - not %v (err=%v)\n", list, err)
+	}
+	filtered, err := people.Filter(sj.Filter{Field: "name", Value: "Test1", Compare: sj.Eq})
+	if err != nil || len(filtered) != 1 {
+		t.Errorf("should filter to 1 - not %v (err=%v)\n", filtered, err)
+	}
+	since, err := people.Since(start)
+	if err != nil || len(since) != 1 {
+		t.Errorf("should be 1 since start - not %v (err=%v)\n", since, err)
+	}
+
+	err = people.Stage(TestPerson{Name: "No Id"})
+	if err == nil {
+		t.Error("staging without an id should fail\n")
+	}
+}
+
+func TestCollectionWithoutValidate(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+
+	people := sj.RegisterCollection[TestPerson]("person",
+		func(p TestPerson) string { return p.Id }, nil)
+	err := people.Stage(TestPerson{Id: "per0000001", Name: "Test1"})
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	// NOTE: only has to unmarshal into TestPerson
+	summary, err := sj.TransferAll("person", nil)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Inserted != 1 {
+		t.Errorf("per0000001 should be inserted - not %s\n", summary)
+	}
+
+	sj.ClearCollections()
+	if _, ok := sj.GetIdExtractor("person"); ok {
+		t.Error("the collection's id extractor should be cleared too\n")
+	}
+}
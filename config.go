@@ -1,12 +1,28 @@
 package scramjet
 
-import "log"
+import (
+	"log"
+	"time"
+)
 
 // more flexible?
 type Config struct {
 	Database DatabaseInfo
 	Logger   *Logger
 	LogLevel LogLevel
+	// pg_notify on every resource change (see ListenForChanges)
+	NotifyChanges bool
+	// outbox row for every change, in the same transaction (see ClaimOutbox)
+	UseOutbox bool
+	// transfer summaries list the identifiers, not just counts
+	SummaryIds bool
+	// json patch on every update (Change.Patch, outbox and deliveries)
+	TrackPatches bool
+	// per type advisory lock around pipeline runs (see LockTypes)
+	Locking     LockMode
+	LockTimeout time.Duration
+	// most deletes a diff can flag for a type (see RegisterDeleteThreshold)
+	DeleteThreshold DeleteThreshold
 }
 
 type DatabaseInfo struct {
@@ -46,6 +62,13 @@ func Configure(conf Config) {
 	SetLogger(logger)
 	SetLogLevel(INFO)
 
+	// NOTE: the lock holds a connection for the whole run - with only
+	// one, everything else in the run would wait on Acquire forever
+	if conf.Locking != LockNone && conf.Database.MaxConnections < 2 {
+		log.Fatalf("Locking needs MaxConnections of at least 2 (not %d)\n",
+			conf.Database.MaxConnections)
+	}
+
 	err := MakeConnectionPool(conf)
 	if err != nil {
 		log.Fatalf("could not make connection pool to database %s:%s\n",
@@ -55,10 +78,39 @@ func Configure(conf Config) {
 	// NOTE: these will log.Fatal too
 	if !StagingTableExists() {
 		MakeStagingSchema()
+	} else {
+		MigrateStagingSchema()
 	}
 	if !ResourceTableExists() {
 		MakeResourceSchema()
 	}
+	if !SinkDeliveryTableExists() {
+		MakeSinkDeliverySchema()
+	}
+	if !WebhookDeadLetterTableExists() {
+		MakeWebhookDeadLetterSchema()
+	}
+	if !OutboxTableExists() {
+		MakeOutboxSchema()
+	}
+	if !ResourceOrphansTableExists() {
+		MakeResourceOrphansSchema()
+	}
+	if !IntakeErrorsTableExists() {
+		MakeIntakeErrorsSchema()
+	}
+	if !IntakeCheckpointsTableExists() {
+		MakeIntakeCheckpointsSchema()
+	}
+	if !ScramjetRunsTableExists() {
+		MakeScramjetRunsSchema()
+	}
+	if conf.NotifyChanges {
+		err = EnableChangeNotifications()
+		if err != nil {
+			log.Fatalf("could not enable change notifications %v\n", err)
+		}
+	}
 }
 
 func Shutdown() {
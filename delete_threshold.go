@@ -0,0 +1,109 @@
+package scramjet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// most a diff (ProcessDiff, ProcessOutake etc...) can flag for delete in
+// one go - 0 is no limit
+type DeleteThreshold struct {
+	MaxCount int
+	// of what's in resources (or the filtered part of it) e.g. 10.0
+	MaxPercent float64
+}
+
+func (t DeleteThreshold) exceeded(deletes int, existing int) bool {
+	if t.MaxCount > 0 && deletes > t.MaxCount {
+		return true
+	}
+	if t.MaxPercent > 0 && existing > 0 &&
+		float64(deletes)*100/float64(existing) > t.MaxPercent {
+		return true
+	}
+	return false
+}
+
+var thresholdMutex sync.RWMutex
+var deleteThresholds = make(map[string]DeleteThreshold)
+
+// overrides Config.DeleteThreshold for the type
+func RegisterDeleteThreshold(typeName string, threshold DeleteThreshold) {
+	thresholdMutex.Lock()
+	defer thresholdMutex.Unlock()
+	deleteThresholds[typeName] = threshold
+}
+
+func ClearDeleteThresholds() {
+	thresholdMutex.Lock()
+	defer thresholdMutex.Unlock()
+	deleteThresholds = make(map[string]DeleteThreshold)
+}
+
+func GetDeleteThreshold(typeName string) DeleteThreshold {
+	thresholdMutex.RLock()
+	defer thresholdMutex.RUnlock()
+	if threshold, ok := deleteThresholds[typeName]; ok {
+		return threshold
+	}
+	if Cfg == nil {
+		return DeleteThreshold{}
+	}
+	return GetConfig().DeleteThreshold
+}
+
+// the deletes a diff would have flagged, but there were too many - nothing
+// was flagged.  To go ahead anyway run it again with OverrideToken set to
+// Token (it only works for these exact deletes)
+type BlockedDeletesError struct {
+	TypeName  string
+	Existing  int
+	Deletes   []Identifier
+	Threshold DeleteThreshold
+	Token     string
+}
+
+func (e BlockedDeletesError) Error() string {
+	return fmt.Sprintf("blocked %d %s deletes of %d (max count=%d percent=%.1f) - override token %s",
+		len(e.Deletes), e.TypeName, e.Existing, e.Threshold.MaxCount, e.Threshold.MaxPercent, e.Token)
+}
+
+// same deletes, same token
+func deletesToken(typeName string, deletes []Identifiable) string {
+	ids := make([]string, 0, len(deletes))
+	for _, item := range deletes {
+		ids = append(ids, item.Identifier().Id)
+	}
+	sort.Strings(ids)
+	hash := sha256.New()
+	hash.Write([]byte(typeName))
+	for _, id := range ids {
+		hash.Write([]byte{0})
+		hash.Write([]byte(id))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// nil if under the type's threshold (or overridden)
+func checkDeleteThreshold(typeName string, deletes []Identifiable, existing int, overrideToken string) error {
+	threshold := GetDeleteThreshold(typeName)
+	if !threshold.exceeded(len(deletes), existing) {
+		return nil
+	}
+	token := deletesToken(typeName, deletes)
+	if len(overrideToken) > 0 && overrideToken == token {
+		GetLogger().Info(fmt.Sprintf("> %d %s deletes over the threshold - overridden\n",
+			len(deletes), typeName))
+		return nil
+	}
+	blocked := BlockedDeletesError{TypeName: typeName, Existing: existing,
+		Threshold: threshold, Token: token}
+	for _, item := range deletes {
+		blocked.Deletes = append(blocked.Deletes, item.Identifier())
+	}
+	GetLogger().Info(blocked.Error() + "\n")
+	return blocked
+}
@@ -0,0 +1,216 @@
+package scramjet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// what ScramjetIntake (or Traject with in = nil) would do - intake goes
+// into a scratch copy of staging, gets validated and compared by hash
+// with resources, then everything is rolled back
+func dryRunTransfer(in *IntakeConfig, config TrajectConfig) (TransferSummary, error) {
+	db := GetPool()
+	ctx := context.Background()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return TransferSummary{}, errors.Wrap(err, "starting transaction")
+	}
+	// NOTE: never committed - this is the point
+	defer tx.Rollback(ctx)
+
+	stamp := TimestampString()
+	scratch := fmt.Sprintf("staging_dry_%s", stamp)
+	incoming := fmt.Sprintf("staging_dry_in_%s", stamp)
+
+	tmpSql := fmt.Sprintf(`CREATE TEMPORARY TABLE %s
+	  (id text NOT NULL, type text NOT NULL, data json NOT NULL,
+		PRIMARY KEY(id, type)
+	  )
+	  ON COMMIT DROP
+	`, scratch)
+	_, err = tx.Exec(ctx, tmpSql)
+	if err != nil {
+		return TransferSummary{}, errors.Wrap(err, "creating scratch table")
+	}
+	tmpSql = fmt.Sprintf(`CREATE TEMPORARY TABLE %s
+	  (id text NOT NULL, type text NOT NULL, data json NOT NULL)
+	  ON COMMIT DROP
+	`, incoming)
+	_, err = tx.Exec(ctx, tmpSql)
+	if err != nil {
+		return TransferSummary{}, errors.Wrap(err, "creating scratch table")
+	}
+
+	// start with whatever is already staged (not the ones flagged for
+	// delete - their data is just {})
+	sqlCopy := fmt.Sprintf(`INSERT INTO %s (id, type, data)
+	  SELECT id, type, data FROM staging WHERE type = $1
+	  AND to_delete IS NOT TRUE`, scratch)
+	_, err = tx.Exec(ctx, sqlCopy, config.TypeName)
+	if err != nil {
+		return TransferSummary{}, errors.Wrap(err, "copying staging to scratch table")
+	}
+
+	if in != nil {
+		// NOTE: same steps as BulkAddStaging, chunk by chunk - but no
+		// checkpoints (nothing is really staged)
+		dryIn := *in
+		dryIn.Checkpoint = ""
+		err = eachIntakeChunk(dryIn, func(list []Storeable) error {
+			return dryRunStash(ctx, tx, scratch, incoming, list)
+		})
+		if err != nil {
+			return TransferSummary{}, err
+		}
+	}
+
+	filterSql := ""
+	if config.Filter != nil {
+		filterSql = "AND " + buildStagingFilterSql(*config.Filter)
+	}
+	sqlCompare := fmt.Sprintf(`SELECT d.id, d.type, d.data, r.hash
+	  FROM (
+	    SELECT id, type, data FROM %s
+	    WHERE type = $1
+	    %s
+	  ) d
+	  LEFT JOIN resources r ON (r.id = d.id AND r.type = d.type)
+	`, scratch, filterSql)
+	rows, err := tx.Query(ctx, sqlCompare, config.TypeName)
+	if err != nil {
+		return TransferSummary{}, errors.Wrap(err, "comparing scratch table with resources")
+	}
+	defer rows.Close()
+
+	validator := registeredValidator(config.TypeName, config.Validator)
+	candidates := make([]Identifiable, 0)
+	existingHashes := make(map[Identifier]*string)
+	for rows.Next() {
+		var item StagingResource
+		var existingHash *string
+		err = rows.Scan(&item.Id, &item.Type, &item.Data, &existingHash)
+		if err != nil {
+			return TransferSummary{}, errors.Wrap(err, "cannot scan in scratch row")
+		}
+		if validator != nil && !validator(string(item.Data)) {
+			continue
+		}
+		candidates = append(candidates, item)
+		existingHashes[item.Identifier()] = existingHash
+	}
+	if rows.Err() != nil {
+		return TransferSummary{}, rows.Err()
+	}
+	rows.Close()
+
+	// NOTE: parents can be in the scratch table too - like they'd be in
+	// staging after a real intake
+	for _, relationship := range enforcedReferences(config.TypeName) {
+		missing, err := checkReferences(ctx, tx, relationship, candidates, scratch)
+		if err != nil {
+			return TransferSummary{}, err
+		}
+		passed := make([]Identifiable, 0)
+		for _, item := range candidates {
+			if _, ok := missing[item.Identifier()]; !ok {
+				passed = append(passed, item)
+			}
+		}
+		candidates = passed
+	}
+
+	items := make([]StagingResource, 0)
+	changes := make([]Change, 0)
+	for _, candidate := range candidates {
+		item := candidate.(StagingResource)
+		items = append(items, item)
+
+		hash, err := hashResource(item.Type, item.Data)
+		if err != nil {
+			return TransferSummary{}, err
+		}
+		existingHash := existingHashes[item.Identifier()]
+		if existingHash == nil {
+			changes = append(changes, Change{Id: item.Identifier(), Op: AddOp, Hash: hash})
+		} else if *existingHash != hash {
+			changes = append(changes, Change{Id: item.Identifier(), Op: UpdateOp,
+				Hash: hash, PreviousHash: *existingHash})
+		}
+	}
+	return summarizeTransfer(items, changes), nil
+}
+
+func dryRunStash(ctx context.Context, tx pgx.Tx, scratch string, incoming string, list []Storeable) error {
+	// NOTE: anything that fails a transform or has no id is just left out
+	list, _, _ = applyTransforms(list)
+	list, _ = resolveIds(list)
+	inputRows := [][]interface{}{}
+	for _, item := range uniqueObjects(list) {
+		str, err := json.Marshal(item.Object())
+		if err != nil {
+			continue
+		}
+		inputRows = append(inputRows, []interface{}{item.Identifier().Id, item.Identifier().Type, str})
+	}
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{incoming},
+		[]string{"id", "type", "data"},
+		pgx.CopyFromRows(inputRows))
+	if err != nil {
+		return errors.Wrap(err, "copying into scratch table")
+	}
+	sqlUpsert := fmt.Sprintf(`INSERT INTO %s (id, type, data)
+	  SELECT id, type, data FROM %s
+	  ON CONFLICT (id, type) DO UPDATE SET data = EXCLUDED.data
+	`, scratch, incoming)
+	_, err = tx.Exec(ctx, sqlUpsert)
+	if err != nil {
+		return errors.Wrap(err, "move into scratch table")
+	}
+	_, err = tx.Exec(ctx, fmt.Sprintf(`DELETE FROM %s`, incoming))
+	return err
+}
+
+// what Eject would remove - the diff plus whatever is already flagged
+// in staging, as long as it is actually in resources
+func dryRunRemove(config OutakeConfig) (TransferSummary, error) {
+	deletes, err := diffDeletes(outakeDiffConfig(config))
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	flagged, err := RetrieveDeletedStaging(config.TypeName)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	ids := []string{}
+	for _, item := range unique(append(deletes, flagged...)) {
+		ids = append(ids, item.Identifier().Id)
+	}
+
+	db := GetPool()
+	ctx := context.Background()
+	sql := `SELECT id FROM resources WHERE type = $1 AND id = ANY($2)`
+	rows, err := db.Query(ctx, sql, config.TypeName, ids)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	defer rows.Close()
+
+	existing := make([]Identifiable, 0)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return TransferSummary{}, errors.Wrap(err, "cannot scan in resource id")
+		}
+		existing = append(existing, MakeStub(id, config.TypeName))
+	}
+	if rows.Err() != nil {
+		return TransferSummary{}, rows.Err()
+	}
+	return summarizeDeletes(existing), nil
+}
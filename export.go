@@ -0,0 +1,434 @@
+package scramjet
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type ExportFormat string
+
+const (
+	NDJSON    ExportFormat = "ndjson"
+	JSONArray ExportFormat = "json"
+	CSV       ExportFormat = "csv"
+)
+
+// a flattened (csv) column - Path is relative to the resource data
+// e.g. ExportColumn{Name: "city", Path: "$.address.city"}
+type ExportColumn struct {
+	Name string
+	Path string
+}
+
+type ExportConfig struct {
+	TypeName string
+	Format   ExportFormat
+	Filter   *Filter
+	Since    *time.Time // only resources updated after this
+	Columns  []ExportColumn
+}
+
+// what is written per resource for ndjson and json formats
+// NOTE: it is also a 'Storeable' so it can go right back into staging
+type SnapshotRecord struct {
+	Id        string          `json:"id"`
+	Type      string          `json:"type"`
+	Hash      string          `json:"hash"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+func (rec SnapshotRecord) Identifier() Identifier {
+	return Identifier{rec.Id, rec.Type}
+}
+
+func (rec SnapshotRecord) Object() interface{} {
+	return rec.Data
+}
+
+// columns used for csv if none are specified
+var defaultExportColumns = []string{"id", "type", "hash", "updated_at", "data"}
+
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch ExportFormat(strings.ToLower(name)) {
+	case NDJSON:
+		return NDJSON, nil
+	case JSONArray:
+		return JSONArray, nil
+	case CSV:
+		return CSV, nil
+	}
+	return "", errors.New(fmt.Sprintf("unknown export format:%s", name))
+}
+
+// writes resources of one type to w as they are read from the database
+// returns how many were written
+func ExportResources(w io.Writer, config ExportConfig) (int, error) {
+	var writer snapshotWriter
+	switch config.Format {
+	case NDJSON, "":
+		writer = &ndjsonWriter{out: w}
+	case JSONArray:
+		writer = &jsonArrayWriter{out: w}
+	case CSV:
+		writer = &csvWriter{out: csv.NewWriter(w), columns: config.Columns}
+	default:
+		return 0, errors.New(fmt.Sprintf("unknown export format:%s", config.Format))
+	}
+
+	db := GetPool()
+	ctx := context.Background()
+
+	args := []interface{}{config.TypeName}
+	sql := `SELECT id, type, hash, data, created_at, updated_at
+	FROM resources
+	WHERE type = $1`
+	if config.Since != nil {
+		args = append(args, *config.Since)
+		sql += fmt.Sprintf(" AND updated_at > $%d", len(args))
+	}
+	if config.Filter != nil {
+		sql += fmt.Sprintf(" AND %s", buildResourceFilterSql(*config.Filter))
+	}
+	sql += " ORDER BY id"
+
+	GetLogger().Debug(fmt.Sprintf("export-sql=%s\n", sql))
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "querying resources for export")
+	}
+	defer rows.Close()
+
+	err = writer.Begin()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for rows.Next() {
+		var rec SnapshotRecord
+		var data []byte
+		err = rows.Scan(&rec.Id, &rec.Type, &rec.Hash, &data, &rec.CreatedAt, &rec.UpdatedAt)
+		if err != nil {
+			return count, errors.Wrap(err, "cannot scan in resource")
+		}
+		rec.Data = data
+		err = writer.Write(rec)
+		if err != nil {
+			return count, errors.Wrap(err, fmt.Sprintf("writing %s", rec.Identifier()))
+		}
+		count++
+	}
+	if rows.Err() != nil {
+		return count, errors.Wrap(rows.Err(), "reading resources for export")
+	}
+	return count, writer.End()
+}
+
+type snapshotWriter interface {
+	Begin() error
+	Write(rec SnapshotRecord) error
+	End() error
+}
+
+type ndjsonWriter struct {
+	out io.Writer
+}
+
+func (w *ndjsonWriter) Begin() error { return nil }
+
+func (w *ndjsonWriter) Write(rec SnapshotRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.out.Write(append(line, '\n'))
+	return err
+}
+
+func (w *ndjsonWriter) End() error { return nil }
+
+type jsonArrayWriter struct {
+	out   io.Writer
+	count int
+}
+
+func (w *jsonArrayWriter) Begin() error {
+	_, err := io.WriteString(w.out, "[")
+	return err
+}
+
+func (w *jsonArrayWriter) Write(rec SnapshotRecord) error {
+	element, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if w.count > 0 {
+		element = append([]byte(",\n"), element...)
+	}
+	w.count++
+	_, err = w.out.Write(element)
+	return err
+}
+
+func (w *jsonArrayWriter) End() error {
+	_, err := io.WriteString(w.out, "]\n")
+	return err
+}
+
+type csvWriter struct {
+	out     *csv.Writer
+	columns []ExportColumn
+}
+
+func (w *csvWriter) Begin() error {
+	header := []string{"id", "type"}
+	if len(w.columns) == 0 {
+		header = defaultExportColumns
+	}
+	for _, col := range w.columns {
+		header = append(header, col.Name)
+	}
+	return w.out.Write(header)
+}
+
+func (w *csvWriter) Write(rec SnapshotRecord) error {
+	if len(w.columns) == 0 {
+		return w.out.Write([]string{rec.Id, rec.Type, rec.Hash,
+			rec.UpdatedAt.Format(time.RFC3339), string(rec.Data)})
+	}
+	// NOTE: UseNumber so big numbers are written as they were
+	decoder := json.NewDecoder(bytes.NewReader(rec.Data))
+	decoder.UseNumber()
+	var doc interface{}
+	err := decoder.Decode(&doc)
+	if err != nil {
+		return err
+	}
+	line := []string{rec.Id, rec.Type}
+	for _, col := range w.columns {
+		value, found := lookupPath(doc, col.Path)
+		line = append(line, csvValue(value, found))
+	}
+	return w.out.Write(line)
+}
+
+func (w *csvWriter) End() error {
+	w.out.Flush()
+	return w.out.Error()
+}
+
+// an empty cell is nothing there - anything else is json, except strings
+// that couldn't be mistaken for json (so "Test1" is Test1, but "123",
+// "true", "null" and "" are written quoted) - see csvCell
+func csvValue(value interface{}, found bool) string {
+	if !found {
+		return ""
+	}
+	if str, ok := value.(string); ok && len(str) > 0 && !json.Valid([]byte(str)) {
+		return str
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(encoded)
+}
+
+// reads a snapshot made by ExportResources back into staging (not resources)
+// so it goes through the usual validate/transfer steps
+// NOTE: csv snapshots only have the columns that were exported (unless
+// they were made with the default columns, which include 'data') - those
+// need the same columns they were exported with, to put each value back
+// at its Path
+func ImportSnapshot(r io.Reader, format ExportFormat, columns ...ExportColumn) (int, error) {
+	var reader func(func(SnapshotRecord) error) error
+	switch format {
+	case NDJSON, "":
+		reader = func(fn func(SnapshotRecord) error) error { return readNDJSON(r, fn) }
+	case JSONArray:
+		reader = func(fn func(SnapshotRecord) error) error { return readJSONArray(r, fn) }
+	case CSV:
+		reader = func(fn func(SnapshotRecord) error) error { return readCSV(r, columns, fn) }
+	default:
+		return 0, errors.New(fmt.Sprintf("unknown import format:%s", format))
+	}
+
+	count := 0
+	batch := make([]Storeable, 0)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := BulkAddStaging(batch...)
+		if err != nil {
+			return err
+		}
+		count += len(batch)
+		batch = make([]Storeable, 0)
+		return nil
+	}
+	err := reader(func(rec SnapshotRecord) error {
+		// NOTE: no id is okay if the type has an IdExtractor
+		_, canExtract := GetIdExtractor(rec.Type)
+		if len(rec.Type) == 0 || (len(rec.Id) == 0 && !canExtract) {
+			return errors.New("snapshot record missing id or type")
+		}
+		batch = append(batch, rec)
+		if len(batch) >= 500 {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return count, errors.Wrap(err, "importing snapshot")
+	}
+	err = flush()
+	if err != nil {
+		return count, errors.Wrap(err, "importing snapshot")
+	}
+	return count, nil
+}
+
+func readNDJSON(r io.Reader, fn func(SnapshotRecord) error) error {
+	scanner := bufio.NewScanner(r)
+	// NOTE: records can be bigger than default 64k line
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		var rec SnapshotRecord
+		err := json.Unmarshal([]byte(line), &rec)
+		if err != nil {
+			return err
+		}
+		err = fn(rec)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func readJSONArray(r io.Reader, fn func(SnapshotRecord) error) error {
+	decoder := json.NewDecoder(r)
+	_, err := decoder.Token() // [
+	if err != nil {
+		return err
+	}
+	for decoder.More() {
+		var rec SnapshotRecord
+		err = decoder.Decode(&rec)
+		if err != nil {
+			return err
+		}
+		err = fn(rec)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = decoder.Token() // ]
+	return err
+}
+
+func readCSV(r io.Reader, columns []ExportColumn, fn func(SnapshotRecord) error) error {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	paths := make(map[string]string)
+	for _, col := range columns {
+		paths[col.Name] = col.Path
+	}
+	hasData := false
+	for _, name := range header {
+		if name == "data" {
+			hasData = true
+		}
+	}
+	for _, name := range header {
+		switch name {
+		case "id", "type", "hash", "updated_at", "created_at", "data":
+		default:
+			// NOTE: without the path it would come back as a top level
+			// field - a different shape than what was exported
+			if _, ok := paths[name]; !ok && !hasData {
+				return errors.New(fmt.Sprintf("no ExportColumn for csv column %s", name))
+			}
+		}
+	}
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rec := SnapshotRecord{}
+		doc := make(map[string]interface{})
+		for i, name := range header {
+			switch name {
+			case "id":
+				rec.Id = line[i]
+			case "type":
+				rec.Type = line[i]
+			case "hash", "updated_at", "created_at":
+				// recalculated on the way into resources
+			case "data":
+				rec.Data = json.RawMessage(line[i])
+			default:
+				if hasData {
+					continue
+				}
+				value, found, err := csvCell(line[i])
+				if err != nil {
+					return errors.Wrap(err, fmt.Sprintf("csv column %s", name))
+				}
+				if found {
+					setPath(doc, paths[name], value)
+				}
+			}
+		}
+		if rec.Data == nil {
+			rec.Data, err = json.Marshal(doc)
+			if err != nil {
+				return err
+			}
+		}
+		err = fn(rec)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// the other way from csvValue - empty is nothing there (not null)
+func csvCell(value string) (interface{}, bool, error) {
+	if len(value) == 0 {
+		return nil, false, nil
+	}
+	if !json.Valid([]byte(value)) {
+		return value, true, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	var parsed interface{}
+	err := decoder.Decode(&parsed)
+	if err != nil {
+		return nil, false, err
+	}
+	return parsed, true, nil
+}
@@ -0,0 +1,150 @@
+package scramjet_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+func stashPeople(t *testing.T, typeName string, people ...TestPersonExtended) {
+	records := []sj.Storeable{}
+	for _, person := range people {
+		records = append(records, sj.MakePacket(person.Id, typeName, person))
+	}
+	err := sj.StashStaging(records...)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	alwaysOkay := func(json string) bool { return true }
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+
+	stashPeople(t, typeName,
+		TestPersonExtended{Id: "per0000001", Name: "Test1", ExternalId: "x100"},
+		TestPersonExtended{Id: "per0000002", Name: "Test2", ExternalId: "x200"},
+	)
+
+	var buf bytes.Buffer
+	count, err := sj.ExportResources(&buf, sj.ExportConfig{TypeName: typeName, Format: sj.NDJSON})
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if count != 2 {
+		t.Errorf("should have exported 2 records - not %d\n", count)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Errorf("should be 2 lines of ndjson - not %d\n", len(lines))
+	}
+
+	// now pretend it's a fresh database
+	sj.ClearAllResources()
+	imported, err := sj.ImportSnapshot(&buf, sj.NDJSON)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if imported != 2 {
+		t.Errorf("should have imported 2 records - not %d\n", imported)
+	}
+	staged, _ := sj.RetrieveTypeStaging(typeName)
+	if len(staged) != 2 {
+		t.Errorf("should be 2 records in staging - not %d\n", len(staged))
+	}
+}
+
+func TestExportFilteredCSV(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+
+	stashPeople(t, typeName,
+		TestPersonExtended{Id: "per0000001", Name: "Test1", ExternalId: "x100"},
+		TestPersonExtended{Id: "per0000002", Name: "Test2", ExternalId: "x200"},
+	)
+
+	filter := sj.Filter{Field: "externalId", Value: "x200", Compare: sj.Eq}
+	config := sj.ExportConfig{
+		TypeName: typeName,
+		Format:   sj.CSV,
+		Filter:   &filter,
+		Columns:  []sj.ExportColumn{{Name: "name", Path: "$.name"}},
+	}
+	var buf bytes.Buffer
+	_, err := sj.ExportResources(&buf, config)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	// header + 1 record
+	if len(rows) != 2 {
+		t.Fatalf("should be 2 csv rows - not %d\n", len(rows))
+	}
+	if rows[1][0] != "per0000002" || rows[1][2] != "Test2" {
+		t.Errorf("unexpected csv row %v\n", rows[1])
+	}
+}
+
+func TestImportColumnCSV(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+
+	data := `{"address":{"city":"123"},"big":12345678901234567890,"flag":"true","id":"per0000001","name":"Test1"}`
+	err := sj.BulkAddStaging(sj.MakePacket("per0000001", typeName, json.RawMessage(data)))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.TransferAll(typeName, func(json string) bool { return true })
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	columns := []sj.ExportColumn{
+		{Name: "id", Path: "$.id"},
+		{Name: "city", Path: "$.address.city"},
+		{Name: "big", Path: "$.big"},
+		{Name: "name", Path: "$.name"},
+		{Name: "flag", Path: "$.flag"},
+	}
+	config := sj.ExportConfig{TypeName: typeName, Format: sj.CSV, Columns: columns}
+	var buf bytes.Buffer
+	_, err = sj.ExportResources(&buf, config)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	exported := buf.String()
+
+	_, err = sj.ImportSnapshot(strings.NewReader(exported), sj.CSV)
+	if err == nil {
+		t.Error("csv columns without their paths should not import\n")
+	}
+	sj.ClearAllStaging()
+	_, err = sj.ImportSnapshot(strings.NewReader(exported), sj.CSV, columns...)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	staged, err := sj.RetrieveSingleStaging("per0000001", typeName)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	// NOTE: same keys (sorted) as data, so it should be the same exactly
+	if string(staged.Data) != data {
+		t.Errorf("should round trip to %s - not %s\n", data, staged.Data)
+	}
+}
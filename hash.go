@@ -0,0 +1,256 @@
+package scramjet
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+type HashAlgorithm string
+
+const (
+	HashMD5    HashAlgorithm = "md5" // default
+	HashSHA256 HashAlgorithm = "sha256"
+)
+
+// how the resources hash is made for a type - the default (no options)
+// is md5 of the json exactly as it was sent
+type HashOptions struct {
+	// sorted keys, no whitespace, numbers written the same way
+	// (so {"b": 1.0, "a": 2} and {"a":2,"b":1} hash the same)
+	Canonical bool
+	Algorithm HashAlgorithm
+	// json paths left out of the hash e.g. "lastFetched", "$.meta.fetched"
+	// or "authors.*.fetched" - NOTE: anything excluded means canonical too
+	Exclude []string
+}
+
+func (o HashOptions) isDefault() bool {
+	return !o.Canonical && len(o.Exclude) == 0 &&
+		(o.Algorithm == "" || o.Algorithm == HashMD5)
+}
+
+var hashMutex sync.RWMutex
+var hashOptions = make(map[string]HashOptions)
+
+// NOTE: changing these for a type with resources already saved will make
+// everything look updated on the next run - see RehashResources
+func RegisterHashOptions(typeName string, options HashOptions) {
+	hashMutex.Lock()
+	defer hashMutex.Unlock()
+	hashOptions[typeName] = options
+}
+
+func ClearHashOptions() {
+	hashMutex.Lock()
+	defer hashMutex.Unlock()
+	hashOptions = make(map[string]HashOptions)
+}
+
+func GetHashOptions(typeName string) HashOptions {
+	hashMutex.RLock()
+	defer hashMutex.RUnlock()
+	return hashOptions[typeName]
+}
+
+// the hash that goes in resources for this type
+func hashResource(typeName string, data []byte) (string, error) {
+	options := GetHashOptions(typeName)
+	if options.isDefault() {
+		return makeHash(string(data)), nil
+	}
+	text := data
+	if options.Canonical || len(options.Exclude) > 0 {
+		var err error
+		text, err = canonicalJSON(data, options.Exclude...)
+		if err != nil {
+			return "", errors.Wrap(err, fmt.Sprintf("could not hash %s", typeName))
+		}
+	}
+	if options.Algorithm == HashSHA256 {
+		sum := sha256.Sum256(text)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	return makeHash(string(text)), nil
+}
+
+// re-written with sorted keys, no whitespace and numbers normalized,
+// without anything at the excluded paths
+func canonicalJSON(data []byte, exclude ...string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	err := decoder.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range exclude {
+		deletePath(doc, path)
+	}
+	var buf bytes.Buffer
+	err = writeCanonical(&buf, doc)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, node interface{}) error {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, key)
+			buf.WriteByte(':')
+			err := writeCanonical(buf, value[key])
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range value {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			err := writeCanonical(buf, item)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case json.Number:
+		buf.WriteString(normalizeNumber(value))
+	case string:
+		writeCanonicalString(buf, value)
+	default:
+		// bool and nil
+		text, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.Write(text)
+	}
+	return nil
+}
+
+func writeCanonicalString(buf *bytes.Buffer, text string) {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(text)
+	// NOTE: Encode adds a newline
+	buf.Truncate(buf.Len() - 1)
+}
+
+// 1, 1.0 and 1e0 all come out as 1
+// NOTE: plain integers keep every digit - through a float64 ones past
+// 2^53 that differ would come out the same
+func normalizeNumber(number json.Number) string {
+	text := number.String()
+	if !strings.ContainsAny(text, ".eE") {
+		if i, ok := new(big.Int).SetString(text, 10); ok {
+			return i.String()
+		}
+	}
+	f, err := number.Float64()
+	if err != nil {
+		return text
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// for after changing the hash options of a type - rows whose hash
+// would be different now get the new one (without touching updated_at)
+// so the next transfer doesn't see everything as updated
+func RehashResources(typeName string) (int, error) {
+	db := GetPool()
+	ctx := context.Background()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "starting transaction")
+	}
+	// supposedly no-op if everything okay
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `SELECT id, hash, data FROM resources WHERE type = $1`, typeName)
+	if err != nil {
+		return 0, err
+	}
+	inputRows := [][]interface{}{}
+	for rows.Next() {
+		var id string
+		var hash string
+		var data []byte
+		err = rows.Scan(&id, &hash, &data)
+		if err != nil {
+			rows.Close()
+			return 0, errors.Wrap(err, "cannot scan in resource")
+		}
+		newHash, err := hashResource(typeName, data)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if newHash != hash {
+			inputRows = append(inputRows, []interface{}{id, newHash})
+		}
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return 0, rows.Err()
+	}
+	if len(inputRows) == 0 {
+		return 0, nil
+	}
+
+	table := fmt.Sprintf("rehash_%s", TimestampString())
+	_, err = tx.Exec(ctx, fmt.Sprintf(`CREATE TEMPORARY TABLE %s
+	  (id text PRIMARY KEY, hash text NOT NULL)
+	  ON COMMIT DROP`, table))
+	if err != nil {
+		return 0, errors.Wrap(err, "creating temporary table")
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{table},
+		[]string{"id", "hash"},
+		pgx.CopyFromRows(inputRows))
+	if err != nil {
+		return 0, errors.Wrap(err, "copying into temporary table")
+	}
+	tag, err := tx.Exec(ctx, fmt.Sprintf(`UPDATE resources r
+	  SET hash = t.hash
+	  FROM %s t
+	  WHERE r.id = t.id AND r.type = $1`, table), typeName)
+	if err != nil {
+		return 0, errors.Wrap(err, "updating hashes")
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "commit transaction")
+	}
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,114 @@
+package scramjet_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+type FetchedPerson struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	LastFetched string `json:"lastFetched"`
+}
+
+func TestHashExcludesVolatileFields(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+	alwaysOkay := func(json string) bool { return true }
+
+	sj.RegisterHashOptions(typeName, sj.HashOptions{Canonical: true,
+		Algorithm: sj.HashSHA256, Exclude: []string{"lastFetched"}})
+	defer sj.ClearHashOptions()
+
+	person := FetchedPerson{Id: "per0000001", Name: "Test1", LastFetched: "2022-01-01"}
+	err := sj.StashStaging(sj.MakePacket(person.Id, typeName, person))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	person.LastFetched = "2022-01-02"
+	err = sj.StashStaging(sj.MakePacket(person.Id, typeName, person))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	summary, err := sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Unchanged != 1 || summary.Updated != 0 {
+		t.Errorf("only lastFetched changed - should be unchanged, not %s\n", summary)
+	}
+}
+
+func TestHashLargeIntegers(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+	alwaysOkay := func(json string) bool { return true }
+
+	sj.RegisterHashOptions(typeName, sj.HashOptions{Canonical: true, Algorithm: sj.HashSHA256})
+	defer sj.ClearHashOptions()
+
+	// NOTE: same float64 - only differ past 2^53
+	for i, count := range []string{"9007199254740992", "9007199254740993"} {
+		person := map[string]interface{}{"id": "per0000001", "count": json.Number(count)}
+		err := sj.StashStaging(sj.MakePacket("per0000001", typeName, person))
+		if err != nil {
+			t.Errorf("err=%v\n", err)
+		}
+		summary, err := sj.TransferAll(typeName, alwaysOkay)
+		if err != nil {
+			t.Errorf("err=%v\n", err)
+		}
+		if i == 1 && summary.Updated != 1 {
+			t.Errorf("count changed - should be updated, not %s\n", summary)
+		}
+	}
+}
+
+func TestRehashResources(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+	alwaysOkay := func(json string) bool { return true }
+	defer sj.ClearHashOptions()
+
+	person := FetchedPerson{Id: "per0000001", Name: "Test1", LastFetched: "2022-01-01"}
+	err := sj.StashStaging(sj.MakePacket(person.Id, typeName, person))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	sj.RegisterHashOptions(typeName, sj.HashOptions{Exclude: []string{"lastFetched"}})
+	count, err := sj.RehashResources(typeName)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if count != 1 {
+		t.Errorf("should have rehashed 1 - not %d\n", count)
+	}
+
+	// same data again is not an update
+	err = sj.StashStaging(sj.MakePacket(person.Id, typeName, person))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	summary, err := sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Unchanged != 1 {
+		t.Errorf("should be unchanged after rehash - not %s\n", summary)
+	}
+}
@@ -0,0 +1,184 @@
+package scramjet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// how to get the id out of a record of a type - first of Func, Path
+// or Fields that is set
+type IdExtractor struct {
+	Func func(data []byte) (string, error)
+	// json path e.g. "$.uri" or "person.id"
+	Path string
+	// composite of several fields e.g. []string{"personId", "year"}
+	Fields []string
+	// between the Fields values - default "|"
+	Separator string
+}
+
+// something to stage where the id isn't known yet - it comes from
+// the type's IdExtractor when staged (see RegisterIdExtractor)
+type RawPacket struct {
+	Type string
+	Obj  interface{}
+}
+
+func (p RawPacket) Identifier() Identifier {
+	return Identifier{Type: p.Type}
+}
+
+func (p RawPacket) Object() interface{} {
+	return p.Obj
+}
+
+func MakeRawPacket(typeName string, obj interface{}) RawPacket {
+	return RawPacket{Type: typeName, Obj: obj}
+}
+
+// NOTE: data is staged as is (not re-marshalled from a map)
+func MakeRawJSONPacket(typeName string, data []byte) RawPacket {
+	return RawPacket{Type: typeName, Obj: json.RawMessage(data)}
+}
+
+// a record (by position in what was sent) that was not staged
+type MissingId struct {
+	Index  int
+	Type   string
+	Reason string
+}
+
+type MissingIdError struct {
+	Records []MissingId
+}
+
+func (e MissingIdError) Error() string {
+	first := e.Records[0]
+	return fmt.Sprintf("%d records with no id (first is #%d %s: %s)", len(e.Records),
+		first.Index, first.Type, first.Reason)
+}
+
+var idMutex sync.RWMutex
+var idExtractors = make(map[string]IdExtractor)
+
+func RegisterIdExtractor(typeName string, extractor IdExtractor) {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idExtractors[typeName] = extractor
+}
+
+func ClearIdExtractors() {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idExtractors = make(map[string]IdExtractor)
+}
+
+func removeIdExtractor(typeName string) {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	delete(idExtractors, typeName)
+}
+
+func GetIdExtractor(typeName string) (IdExtractor, bool) {
+	idMutex.RLock()
+	defer idMutex.RUnlock()
+	extractor, ok := idExtractors[typeName]
+	return extractor, ok
+}
+
+// the id of one record (already json)
+func (e IdExtractor) Extract(data []byte) (string, error) {
+	if e.Func != nil {
+		return e.Func(data)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	err := decoder.Decode(&doc)
+	if err != nil {
+		return "", err
+	}
+	if len(e.Path) > 0 {
+		return idValue(doc, e.Path)
+	}
+	if len(e.Fields) > 0 {
+		separator := e.Separator
+		if separator == "" {
+			separator = "|"
+		}
+		values := []string{}
+		for _, field := range e.Fields {
+			value, err := idValue(doc, field)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, value)
+		}
+		return strings.Join(values, separator), nil
+	}
+	return "", errors.New("id extractor has no Func, Path or Fields")
+}
+
+func idValue(doc interface{}, path string) (string, error) {
+	value, ok := lookupPath(doc, path)
+	if !ok || value == nil {
+		return "", errors.New(fmt.Sprintf("nothing at %s", path))
+	}
+	var id string
+	switch v := value.(type) {
+	case string:
+		id = v
+	case json.Number:
+		id = v.String()
+	default:
+		return "", errors.New(fmt.Sprintf("%s is not a string or number", path))
+	}
+	if len(id) == 0 {
+		return "", errors.New(fmt.Sprintf("%s is empty", path))
+	}
+	return id, nil
+}
+
+// anything without an id gets one from its type's extractor - what
+// can't be given one is left out (and listed in missing)
+func resolveIds(items []Storeable) ([]Storeable, []MissingId) {
+	resolved := make([]Storeable, 0, len(items))
+	missing := make([]MissingId, 0)
+	for i, item := range items {
+		id := item.Identifier()
+		if len(id.Id) > 0 {
+			resolved = append(resolved, item)
+			continue
+		}
+		extractor, ok := GetIdExtractor(id.Type)
+		if !ok {
+			missing = append(missing, MissingId{Index: i, Type: id.Type,
+				Reason: "no id and no id extractor for type"})
+			continue
+		}
+		data, err := json.Marshal(item.Object())
+		if err != nil {
+			missing = append(missing, MissingId{Index: i, Type: id.Type, Reason: err.Error()})
+			continue
+		}
+		extracted, err := extractor.Extract(data)
+		if err != nil {
+			missing = append(missing, MissingId{Index: i, Type: id.Type, Reason: err.Error()})
+			continue
+		}
+		// NOTE: a Func can give back "" without an error
+		if len(extracted) == 0 {
+			missing = append(missing, MissingId{Index: i, Type: id.Type,
+				Reason: "id extractor returned an empty id"})
+			continue
+		}
+		resolved = append(resolved, Packet{Id: Identifier{Id: extracted, Type: id.Type},
+			Obj: json.RawMessage(data)})
+	}
+	return resolved, missing
+}
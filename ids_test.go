@@ -0,0 +1,75 @@
+package scramjet_test
+
+import (
+	"testing"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+func TestIdExtractor(t *testing.T) {
+	doc := []byte(`{"uri": "per0000001", "person": {"id": 7}, "year": 2020}`)
+	id, err := sj.IdExtractor{Path: "$.uri"}.Extract(doc)
+	if err != nil || id != "per0000001" {
+		t.Errorf("path should be per0000001 - not %s (err=%v)\n", id, err)
+	}
+	id, err = sj.IdExtractor{Path: "person.id"}.Extract(doc)
+	if err != nil || id != "7" {
+		t.Errorf("nested path should be 7 - not %s (err=%v)\n", id, err)
+	}
+	id, err = sj.IdExtractor{Fields: []string{"uri", "year"}}.Extract(doc)
+	if err != nil || id != "per0000001|2020" {
+		t.Errorf("fields should be per0000001|2020 - not %s (err=%v)\n", id, err)
+	}
+	_, err = sj.IdExtractor{Path: "$.missing"}.Extract(doc)
+	if err == nil {
+		t.Error("missing path should be an error\n")
+	}
+}
+
+func TestStagingWithoutIds(t *testing.T) {
+	sj.ClearAllStaging()
+	defer sj.ClearIdExtractors()
+
+	sj.RegisterIdExtractor("person", sj.IdExtractor{Path: "$.uri"})
+	err := sj.BulkAddStaging(
+		sj.MakeRawJSONPacket("person", []byte(`{"uri": "per0000001", "name": "Test1"}`)),
+		sj.MakeRawPacket("person", map[string]string{"uri": "per0000002", "name": "Test2"}),
+		sj.MakeRawJSONPacket("person", []byte(`{"name": "No Id"}`)),
+		sj.MakeRawJSONPacket("publication", []byte(`{"uri": "pub0000001"}`)),
+	)
+	missing, ok := err.(sj.MissingIdError)
+	if !ok {
+		t.Fatalf("should be a MissingIdError - not %v\n", err)
+	}
+	if len(missing.Records) != 2 || missing.Records[0].Index != 2 || missing.Records[1].Index != 3 {
+		t.Errorf("records 2 and 3 should be missing ids - not %v\n", missing.Records)
+	}
+
+	list, err := sj.RetrieveTypeStaging("person")
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(list) != 2 {
+		t.Errorf("should have staged 2 people - not %d\n", len(list))
+	}
+	staged, err := sj.RetrieveSingleStaging("per0000002", "person")
+	if err != nil || staged.Id != "per0000002" {
+		t.Errorf("should find per0000002 - not %v (err=%v)\n", staged, err)
+	}
+
+	sj.RegisterIdExtractor("grant", sj.IdExtractor{Func: func(data []byte) (string, error) {
+		return "gnt0000001", nil
+	}})
+	err = sj.BulkAddStaging(sj.MakeRawJSONPacket("grant", []byte(`{"title": "Grant1"}`)))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	sj.RegisterIdExtractor("grant", sj.IdExtractor{Func: func(data []byte) (string, error) {
+		return "", nil
+	}})
+	err = sj.BulkAddStaging(sj.MakeRawJSONPacket("grant", []byte(`{"title": "Grant2"}`)))
+	if missing, ok := err.(sj.MissingIdError); !ok || len(missing.Records) != 1 {
+		t.Errorf("an empty id should be missing - not %v\n", err)
+	}
+}
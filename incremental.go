@@ -0,0 +1,131 @@
+package scramjet
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// what the intake of a ScramjetIncremental run is told - Since() is where
+// the last successful run left off ("" for a full sync)
+type IncrementalRun struct {
+	TypeName  string
+	Full      bool
+	StartedAt time.Time
+	// nil if this is the first
+	Previous  *ScramjetRun
+	mutex     sync.Mutex
+	watermark string
+}
+
+func (r *IncrementalRun) Since() string {
+	if r.Full || r.Previous == nil {
+		return ""
+	}
+	return r.Previous.Watermark
+}
+
+// when the last successful run started (zero for a full sync)
+func (r *IncrementalRun) SinceTime() time.Time {
+	if r.Full || r.Previous == nil {
+		return time.Time{}
+	}
+	return r.Previous.StartedAt
+}
+
+// what the next run gets as Since() - e.g. the newest modified date seen
+// NOTE: defaults to StartedAt (RFC3339) if it's never set
+func (r *IncrementalRun) SetWatermark(watermark string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.watermark = watermark
+}
+
+func (r *IncrementalRun) Watermark() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.watermark == "" {
+		return r.StartedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return r.watermark
+}
+
+type IncrementalConfig struct {
+	TypeName string
+	// the intake for this run - only what changed since run.Since() unless
+	// it's a full sync
+	Intake  func(run *IncrementalRun) (IntakeConfig, error)
+	Process TrajectConfig
+	// only used on full syncs (ProcessDiff needs the full list)
+	Outake OutakeConfig
+	// how often to do a full sync - 0 is only the first time
+	FullSyncEvery time.Duration
+}
+
+// Scramjet, but only the records changed since the last successful run -
+// with a full sync (intake of everything and the deletes) the first time
+// and then every FullSyncEvery - each run is kept in scramjet_runs
+// NOTE: without an Outake.ListMaker no run is full (Since() is still ""
+// the first time). A DryRun isn't recorded
+func ScramjetIncremental(config IncrementalConfig) (TransferSummary, error) {
+	lock, err := LockTypes(withDependentTypes(config.TypeName)...)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	defer lock.Unlock()
+
+	previous, err := LastSuccessfulRun(config.TypeName, false)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	lastFull, err := LastSuccessfulRun(config.TypeName, true)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	// NOTE: UTC - started_at is a timestamp without a time zone
+	run := &IncrementalRun{TypeName: config.TypeName, StartedAt: time.Now().UTC(), Previous: previous}
+	// NOTE: only full if the deletes can be found too
+	run.Full = config.Outake.ListMaker != nil && (lastFull == nil ||
+		(config.FullSyncEvery > 0 && run.StartedAt.Sub(lastFull.StartedAt) >= config.FullSyncEvery))
+
+	in, err := config.Intake(run)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	if in.TypeName == "" {
+		in.TypeName = config.TypeName
+	}
+	process := config.Process
+	if process.TypeName == "" {
+		process.TypeName = config.TypeName
+	}
+	out := config.Outake
+	if out.TypeName == "" {
+		out.TypeName = config.TypeName
+	}
+	dryRun := process.DryRun || out.DryRun
+
+	runId := 0
+	if !dryRun {
+		runId, err = startRun(config.TypeName, run.Full, run.StartedAt)
+		if err != nil {
+			return TransferSummary{}, err
+		}
+	}
+	var summary TransferSummary
+	if run.Full {
+		GetLogger().Info(fmt.Sprintf("> full sync of %s\n", config.TypeName))
+		summary, err = scramjet(in, process, out)
+	} else {
+		GetLogger().Info(fmt.Sprintf("> %s changes since %q\n", config.TypeName, run.Since()))
+		summary, err = scramjetIntake(in, process)
+	}
+	if dryRun {
+		return summary, err
+	}
+	finishErr := finishRun(runId, run.Watermark(), summary, err)
+	if err != nil {
+		return summary, err
+	}
+	return summary, finishErr
+}
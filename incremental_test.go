@@ -0,0 +1,96 @@
+package scramjet_test
+
+import (
+	"testing"
+	"time"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+func TestIncrementalRuns(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+	sj.ClearRuns(typeName)
+	defer sj.ClearRuns(typeName)
+
+	// NOTE: "modified" is the watermark
+	source := []IntakePerson{{Id: "per0000001", Name: "Test1"}, {Id: "per0000002", Name: "Test2"}}
+	modified := map[string]string{"per0000001": "2020-01-01", "per0000002": "2020-01-02"}
+	sinces := []string{}
+	config := sj.IncrementalConfig{
+		TypeName: typeName,
+		Intake: func(run *sj.IncrementalRun) (sj.IntakeConfig, error) {
+			sinces = append(sinces, run.Since())
+			listMaker := func(i int) ([]sj.Storeable, error) {
+				list := []sj.Storeable{}
+				newest := run.Since()
+				for _, person := range source {
+					if modified[person.Id] > run.Since() {
+						list = append(list, sj.MakePacket(person.Id, typeName, person))
+						if modified[person.Id] > newest {
+							newest = modified[person.Id]
+						}
+					}
+				}
+				run.SetWatermark(newest)
+				return list, nil
+			}
+			return sj.IntakeConfig{ListMaker: listMaker}, nil
+		},
+		Process: sj.TrajectConfig{Validator: func(json string) bool { return true }},
+		Outake: sj.OutakeConfig{ListMaker: func() ([]string, error) {
+			ids := []string{}
+			for _, person := range source {
+				ids = append(ids, person.Id)
+			}
+			return ids, nil
+		}},
+		FullSyncEvery: time.Hour,
+	}
+
+	summary, err := sj.ScramjetIncremental(config)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Inserted != 2 {
+		t.Errorf("first run should insert 2 - not %s\n", summary)
+	}
+
+	source[1].Name = "Test2 Changed"
+	modified["per0000002"] = "2020-01-03"
+	summary, err = sj.ScramjetIncremental(config)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Updated != 1 || summary.Unchanged != 0 {
+		t.Errorf("second run should only get per0000002 - not %s\n", summary)
+	}
+	if len(sinces) != 2 || sinces[0] != "" || sinces[1] != "2020-01-02" {
+		t.Errorf("should be since nothing, then 2020-01-02 - not %v\n", sinces)
+	}
+
+	runs, err := sj.RetrieveRuns(typeName)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(runs) != 2 || !runs[1].Full || runs[0].Full || runs[0].Watermark != "2020-01-03" {
+		t.Errorf("should be a full run then an incremental one - not %v\n", runs)
+	}
+	last, err := sj.LastSuccessfulRun(typeName, false)
+	if err != nil || last == nil || last.Summary.Updated != 1 {
+		t.Errorf("last run should have updated 1 - not %v (err=%v)\n", last, err)
+	}
+
+	// NOTE: no deletes without an Outake ListMaker - so never full
+	sj.ClearRuns(typeName)
+	config.Outake = sj.OutakeConfig{}
+	_, err = sj.ScramjetIncremental(config)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	full, err := sj.LastSuccessfulRun(typeName, true)
+	if err != nil || full != nil {
+		t.Errorf("should not be recorded as a full run - not %v (err=%v)\n", full, err)
+	}
+}
@@ -0,0 +1,115 @@
+package scramjet
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// pages of records, one after the other - token is "" for the first page
+// and then whatever the page before said was next ("" when there are
+// no more)
+type IntakeSource interface {
+	Page(token string) (list []Storeable, next string, err error)
+}
+
+// continuation tokens, `next` links etc... e.g.
+//
+//	sj.TokenSource(func(token string) ([]sj.Storeable, string, error) {
+//	  resp := oai.ListRecords(token)
+//	  return toPackets(resp.Records), resp.ResumptionToken, nil
+//	})
+type TokenSource func(token string) ([]Storeable, string, error)
+
+func (s TokenSource) Page(token string) ([]Storeable, string, error) {
+	return s(token)
+}
+
+// offsets when the total isn't known - ListMaker(0), ListMaker(PageSize) ...
+// until a page comes back short (or empty)
+type OffsetSource struct {
+	ListMaker IntakeListMaker
+	PageSize  int
+}
+
+func (s OffsetSource) Page(token string) ([]Storeable, string, error) {
+	if s.PageSize <= 0 {
+		return nil, "", errors.New("OffsetSource needs a PageSize")
+	}
+	offset := 0
+	if len(token) > 0 {
+		var err error
+		offset, err = strconv.Atoi(token)
+		if err != nil {
+			return nil, "", errors.Wrap(err, fmt.Sprintf("not an offset %q", token))
+		}
+	}
+	list, err := s.ListMaker(offset)
+	if err != nil {
+		return list, "", err
+	}
+	if len(list) < s.PageSize {
+		return list, "", nil
+	}
+	return list, strconv.Itoa(offset + s.PageSize), nil
+}
+
+// one page at a time until the source is out - with a Checkpoint the
+// next token is kept after each page, so a rerun picks up from there
+func eachSourcePage(ins IntakeConfig, stash func([]Storeable) error) error {
+	var logger = GetLogger()
+
+	token := ""
+	page := 0
+	if len(ins.Checkpoint) > 0 {
+		var found bool
+		var err error
+		page, token, found, err = retrieveTokenCheckpoint(ins)
+		if err != nil {
+			return err
+		}
+		if found {
+			logger.Info(fmt.Sprintf("> resuming %s at page %d (%s)\n", ins.TypeName, page, ins.Checkpoint))
+		}
+	}
+
+	for {
+		logger.Debug(fmt.Sprintf("> retrieving %s page %d\n", ins.TypeName, page))
+		var list []Storeable
+		var next string
+		err := withRetries(ins, func() error {
+			var err error
+			list, next, err = ins.Source.Page(token)
+			return err
+		})
+		if err == nil {
+			err = stash(list)
+		}
+		if err == nil && len(next) > 0 && next == token {
+			err = errors.New(fmt.Sprintf("source gave back the same token %q", token))
+		}
+		if err != nil {
+			failed := ChunkFailure{Offset: page, Token: token, Err: err}
+			return IntakeChunksError{TypeName: ins.TypeName, Failed: []ChunkFailure{failed}}
+		}
+		logger.Debug(fmt.Sprintf("> retrieved %d records\n", len(list)))
+		page++
+		if len(next) == 0 {
+			break
+		}
+		token = next
+		if len(ins.Checkpoint) > 0 {
+			err = recordTokenCheckpoint(ins, page, token)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	logger.Debug(fmt.Sprintf("> finished %s records (%d pages)\n", ins.TypeName, page))
+	// NOTE: all done - so the next run starts over
+	if len(ins.Checkpoint) > 0 {
+		return ClearIntakeCheckpoints(ins.Checkpoint, ins.TypeName)
+	}
+	return nil
+}
@@ -0,0 +1,503 @@
+package scramjet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// one RFC 6902 operation (only add, remove and replace come out of DiffJSON,
+// ApplyJSONPatch does move, copy and test too)
+type PatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"` // move and copy
+	Value json.RawMessage `json:"value,omitempty"`
+	// what was there before (not part of the patch - for Summary)
+	Old json.RawMessage `json:"-"`
+}
+
+type JSONPatch []PatchOp
+
+// field level difference between two versions of a document as a
+// JSON Patch that turns before into after
+// NOTE: arrays that changed length are replaced whole
+func DiffJSON(before []byte, after []byte) (JSONPatch, error) {
+	var left, right interface{}
+	err := decodeJSON(before, &left)
+	if err != nil {
+		return nil, err
+	}
+	err = decodeJSON(after, &right)
+	if err != nil {
+		return nil, err
+	}
+	patch := JSONPatch{}
+	err = diffNodes("", left, right, &patch)
+	return patch, err
+}
+
+func decodeJSON(data []byte, doc *interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(doc)
+}
+
+func diffNodes(path string, left interface{}, right interface{}, patch *JSONPatch) error {
+	leftMap, leftIsMap := left.(map[string]interface{})
+	rightMap, rightIsMap := right.(map[string]interface{})
+	if leftIsMap && rightIsMap {
+		keys := make([]string, 0)
+		for key := range leftMap {
+			keys = append(keys, key)
+		}
+		for key := range rightMap {
+			if _, ok := leftMap[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := path + "/" + escapePointer(key)
+			leftValue, inLeft := leftMap[key]
+			rightValue, inRight := rightMap[key]
+			switch {
+			case !inRight:
+				err := patch.add("remove", child, nil, leftValue)
+				if err != nil {
+					return err
+				}
+			case !inLeft:
+				err := patch.add("add", child, rightValue, nil)
+				if err != nil {
+					return err
+				}
+			default:
+				err := diffNodes(child, leftValue, rightValue, patch)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	leftList, leftIsList := left.([]interface{})
+	rightList, rightIsList := right.([]interface{})
+	if leftIsList && rightIsList && len(leftList) == len(rightList) {
+		for i := range leftList {
+			err := diffNodes(fmt.Sprintf("%s/%d", path, i), leftList[i], rightList[i], patch)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !sameValue(left, right) {
+		return patch.add("replace", path, right, left)
+	}
+	return nil
+}
+
+// NOTE: 1 and 1.0 are the same value
+func sameValue(left interface{}, right interface{}) bool {
+	leftNumber, leftIsNumber := left.(json.Number)
+	rightNumber, rightIsNumber := right.(json.Number)
+	if leftIsNumber && rightIsNumber {
+		return normalizeNumber(leftNumber) == normalizeNumber(rightNumber)
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+func (p *JSONPatch) add(op string, path string, value interface{}, old interface{}) error {
+	patchOp := PatchOp{Op: op, Path: path}
+	if op != "remove" {
+		text, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		patchOp.Value = text
+	}
+	if op != "add" {
+		text, err := json.Marshal(old)
+		if err != nil {
+			return err
+		}
+		patchOp.Old = text
+	}
+	*p = append(*p, patchOp)
+	return nil
+}
+
+// RFC 6901 - "~" and "/" in keys
+func escapePointer(key string) string {
+	key = strings.ReplaceAll(key, "~", "~0")
+	return strings.ReplaceAll(key, "/", "~1")
+}
+
+func unescapePointer(token string) string {
+	token = strings.ReplaceAll(token, "~1", "/")
+	return strings.ReplaceAll(token, "~0", "~")
+}
+
+// "" is the whole document, "/a/0" is ["a", "0"]
+func parsePointer(path string) ([]string, error) {
+	if path == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, errors.New(fmt.Sprintf("invalid json pointer %q", path))
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = unescapePointer(token)
+	}
+	return tokens, nil
+}
+
+// "-" (one past the end) only allowed when adding
+func pointerIndex(token string, length int, adding bool) (int, error) {
+	if adding && token == "-" {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, errors.New(fmt.Sprintf("invalid array index %q", token))
+	}
+	max := length - 1
+	if adding {
+		max = length
+	}
+	if index > max {
+		return 0, errors.New(fmt.Sprintf("array index %d out of range", index))
+	}
+	return index, nil
+}
+
+// RFC 7396 - objects are merged key by key, null removes a key,
+// anything else (arrays too) replaces what was there
+func ApplyMergePatch(doc []byte, patch []byte) ([]byte, error) {
+	var target, changes interface{}
+	err := decodeJSON(doc, &target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing document")
+	}
+	err = decodeJSON(patch, &changes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing merge patch")
+	}
+	return json.Marshal(mergeNodes(target, changes))
+}
+
+func mergeNodes(target interface{}, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = make(map[string]interface{})
+	}
+	for key, value := range patchMap {
+		if value == nil {
+			delete(targetMap, key)
+		} else {
+			targetMap[key] = mergeNodes(targetMap[key], value)
+		}
+	}
+	return targetMap
+}
+
+// RFC 6902 - all or nothing, the first operation that fails (including
+// a failed "test") stops it
+func ApplyJSONPatch(doc []byte, patch JSONPatch) ([]byte, error) {
+	var root interface{}
+	err := decodeJSON(doc, &root)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing document")
+	}
+	for i, op := range patch {
+		root, err = applyPatchOp(root, op)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("patch operation %d (%s %s)", i, op.Op, op.Path))
+		}
+	}
+	return json.Marshal(root)
+}
+
+func applyPatchOp(root interface{}, op PatchOp) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, errors.New("missing value")
+		}
+		var value interface{}
+		err = decodeJSON(op.Value, &value)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "test" {
+			current, err := getNode(root, path)
+			if err != nil {
+				return nil, err
+			}
+			if !sameNode(current, value) {
+				return nil, errors.New("test failed")
+			}
+			return root, nil
+		}
+		if op.Op == "replace" && len(path) == 0 {
+			return value, nil
+		}
+		if op.Op == "replace" {
+			root, err = removeNode(root, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return addNode(root, path, value)
+	case "remove":
+		return removeNode(root, path)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := getNode(root, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, errors.New("cannot move into a child of itself")
+			}
+			root, err = removeNode(root, from)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			// NOTE: a copy that isn't shared with the original
+			text, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			err = decodeJSON(text, &value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return addNode(root, path, value)
+	}
+	return nil, errors.New(fmt.Sprintf("unknown operation %q", op.Op))
+}
+
+// NOTE: same canonical text means the same value (so 1 and 1.0 match)
+func sameNode(left interface{}, right interface{}) bool {
+	var leftBuf, rightBuf bytes.Buffer
+	if writeCanonical(&leftBuf, left) != nil || writeCanonical(&rightBuf, right) != nil {
+		return false
+	}
+	return leftBuf.String() == rightBuf.String()
+}
+
+func getNode(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch container := node.(type) {
+		case map[string]interface{}:
+			child, ok := container[token]
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("no %q", token))
+			}
+			node = child
+		case []interface{}:
+			index, err := pointerIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			node = container[index]
+		default:
+			return nil, errors.New(fmt.Sprintf("cannot look up %q in a value", token))
+		}
+	}
+	return node, nil
+}
+
+// calls change on the container holding the last token, putting
+// back whatever container it returns (arrays change size)
+func changeNode(node interface{}, path []string, change func(interface{}, string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return change(node, path[0])
+	}
+	token := path[0]
+	switch container := node.(type) {
+	case map[string]interface{}:
+		child, ok := container[token]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("no %q", token))
+		}
+		child, err := changeNode(child, path[1:], change)
+		if err != nil {
+			return nil, err
+		}
+		container[token] = child
+		return container, nil
+	case []interface{}:
+		index, err := pointerIndex(token, len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		child, err := changeNode(container[index], path[1:], change)
+		if err != nil {
+			return nil, err
+		}
+		container[index] = child
+		return container, nil
+	}
+	return nil, errors.New(fmt.Sprintf("cannot look up %q in a value", token))
+}
+
+func addNode(root interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return changeNode(root, path, func(node interface{}, token string) (interface{}, error) {
+		switch container := node.(type) {
+		case map[string]interface{}:
+			container[token] = value
+			return container, nil
+		case []interface{}:
+			index, err := pointerIndex(token, len(container), true)
+			if err != nil {
+				return nil, err
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, nil
+		}
+		return nil, errors.New(fmt.Sprintf("cannot add %q to a value", token))
+	})
+}
+
+func removeNode(root interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+	return changeNode(root, path, func(node interface{}, token string) (interface{}, error) {
+		switch container := node.(type) {
+		case map[string]interface{}:
+			if _, ok := container[token]; !ok {
+				return nil, errors.New(fmt.Sprintf("no %q to remove", token))
+			}
+			delete(container, token)
+			return container, nil
+		case []interface{}:
+			index, err := pointerIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(container[:index], container[index+1:]...), nil
+		}
+		return nil, errors.New(fmt.Sprintf("cannot remove %q from a value", token))
+	})
+}
+
+// one line per operation e.g. `/name: "Test1" -> "Test2"`
+func (p JSONPatch) Summary() string {
+	lines := []string{}
+	for _, op := range p {
+		switch op.Op {
+		case "add":
+			lines = append(lines, fmt.Sprintf("%s: added %s", op.Path, op.Value))
+		case "remove":
+			lines = append(lines, fmt.Sprintf("%s: removed (was %s)", op.Path, op.Old))
+		case "replace":
+			if len(op.Old) > 0 {
+				lines = append(lines, fmt.Sprintf("%s: %s -> %s", op.Path, op.Old, op.Value))
+			} else {
+				lines = append(lines, fmt.Sprintf("%s: now %s", op.Path, op.Value))
+			}
+		default:
+			lines = append(lines, fmt.Sprintf("%s: %s", op.Path, op.Op))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func patchesEnabled() bool {
+	conf := GetConfig()
+	return conf != nil && conf.TrackPatches
+}
+
+// fills in Patch on updates (needs Data and PreviousData)
+func addPatches(changes []Change) {
+	for i, change := range changes {
+		if change.Op != UpdateOp || len(change.Data) == 0 || len(change.PreviousData) == 0 {
+			continue
+		}
+		patch, err := DiffJSON(change.PreviousData, change.Data)
+		if err != nil {
+			GetLogger().Info(fmt.Sprintf("could not diff %s: %s\n", change.Id, err))
+			continue
+		}
+		changes[i].Patch = patch
+	}
+}
+
+// json for a patch column (NULL if there isn't one)
+func patchColumn(patch JSONPatch) interface{} {
+	if len(patch) == 0 {
+		return nil
+	}
+	text, err := json.Marshal(patch)
+	if err != nil {
+		return nil
+	}
+	return text
+}
+
+func readPatchColumn(text []byte) JSONPatch {
+	if len(text) == 0 {
+		return nil
+	}
+	var patch JSONPatch
+	err := json.Unmarshal(text, &patch)
+	if err != nil {
+		return nil
+	}
+	return patch
+}
+
+// what transferring one staged item would change in resources
+// (without transferring it) - nil patch if it's not in resources yet
+func DiffStagingResource(id string, typeName string) (JSONPatch, error) {
+	staged, err := RetrieveSingleStaging(id, typeName)
+	if err != nil {
+		return nil, err
+	}
+	db := GetPool()
+	ctx := context.Background()
+	var data []byte
+	err = db.QueryRow(ctx, `SELECT data FROM resources WHERE id = $1 AND type = $2`,
+		id, typeName).Scan(&data)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving resource to diff")
+	}
+	return DiffJSON(data, staged.Data)
+}
@@ -0,0 +1,146 @@
+package scramjet_test
+
+import (
+	"testing"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+func TestDiffJSON(t *testing.T) {
+	before := []byte(`{"id": "per0000001", "name": "Test1", "age": 1.0, "tags": ["a"], "a/b": 1}`)
+	after := []byte(`{"id": "per0000001", "name": "Test2", "age": 1, "tags": ["a", "b"], "email": "x@y.z"}`)
+
+	patch, err := sj.DiffJSON(before, after)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	expected := []sj.PatchOp{
+		{Op: "remove", Path: "/a~1b"},
+		{Op: "add", Path: "/email", Value: []byte(`"x@y.z"`)},
+		{Op: "replace", Path: "/name", Value: []byte(`"Test2"`)},
+		{Op: "replace", Path: "/tags", Value: []byte(`["a","b"]`)},
+	}
+	if len(patch) != len(expected) {
+		t.Fatalf("expected %d operations - not %v\n", len(expected), patch)
+	}
+	for i, op := range expected {
+		if patch[i].Op != op.Op || patch[i].Path != op.Path || string(patch[i].Value) != string(op.Value) {
+			t.Errorf("expected %v - not %v\n", op, patch[i])
+		}
+	}
+	if patch.Summary() == "" {
+		t.Error("summary should not be empty\n")
+	}
+}
+
+func TestDiffJSONLargeIntegers(t *testing.T) {
+	before := []byte(`{"count": 9007199254740992, "same": 12345678901234567890}`)
+	after := []byte(`{"count": 9007199254740993, "same": 12345678901234567890}`)
+
+	patch, err := sj.DiffJSON(before, after)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(patch) != 1 || patch[0].Path != "/count" || string(patch[0].Value) != "9007199254740993" {
+		t.Errorf("only count should be replaced - not %v\n", patch)
+	}
+}
+
+func TestTrackPatches(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	sj.ClearSinks()
+	defer sj.ClearSinks()
+	sj.GetConfig().TrackPatches = true
+	defer func() { sj.GetConfig().TrackPatches = false }()
+	typeName := "person"
+
+	sink := &recordingSink{name: "recorder"}
+	sj.RegisterSink(sink, typeName)
+
+	alwaysOkay := func(json string) bool { return true }
+	person := TestPerson{Id: "per0000001", Name: "Test1"}
+	err := sj.StashStaging(sj.MakePacket(person.Id, typeName, person))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	person.Name = "Test1updated"
+	err = sj.StashStaging(sj.MakePacket(person.Id, typeName, person))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	patch, err := sj.DiffStagingResource(person.Id, typeName)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(patch) != 1 || patch[0].Path != "/name" {
+		t.Errorf("staged diff should only replace /name - not %v\n", patch)
+	}
+
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if sink.count(sj.UpdateOp) != 1 {
+		t.Fatalf("sink should have 1 update - not %d\n", sink.count(sj.UpdateOp))
+	}
+	update := sink.batches[len(sink.batches)-1].Updates[0]
+	if len(update.Patch) != 1 || string(update.Patch[0].Value) != `"Test1updated"` {
+		t.Errorf("update should have a patch for name - not %v\n", update.Patch)
+	}
+}
+
+func TestPatchStaging(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+	alwaysOkay := func(json string) bool { return true }
+
+	// nothing to patch yet
+	missing := sj.Identifier{Id: "per0000009", Type: typeName}
+	err := sj.PatchStaging(missing, sj.MergePatchKind, []byte(`{"name": "Robb"}`))
+	if _, ok := err.(sj.NoBaseRecordError); !ok {
+		t.Errorf("should be a NoBaseRecordError - not %v\n", err)
+	}
+
+	// in resources (and not staging)
+	person := TestPerson{Id: "per0000001", Name: "Rob"}
+	err = sj.StashStaging(sj.MakePacket(person.Id, typeName, person))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	id := sj.Identifier{Id: person.Id, Type: typeName}
+	err = sj.BulkPatchStaging(
+		sj.StagingPatch{Id: id, Kind: sj.MergePatchKind, Patch: []byte(`{"name": "Robb"}`)},
+		sj.StagingPatch{Id: id, Kind: sj.JSONPatchKind,
+			Patch: []byte(`[{"op": "test", "path": "/name", "value": "Robb"},
+			  {"op": "add", "path": "/email", "value": "robb@example.com"}]`)})
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	staged, err := sj.RetrieveSingleStaging(person.Id, typeName)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	expected := `{"email":"robb@example.com","id":"per0000001","name":"Robb"}`
+	if string(staged.Data) != expected {
+		t.Errorf("expected %s - not %s\n", expected, staged.Data)
+	}
+
+	// failed test means nothing changes
+	err = sj.PatchStaging(id, sj.JSONPatchKind,
+		[]byte(`[{"op": "test", "path": "/name", "value": "Rob"}]`))
+	if err == nil {
+		t.Error("patch with failing test should fail\n")
+	}
+}
@@ -0,0 +1,108 @@
+package scramjet
+
+import (
+	"strconv"
+	"strings"
+)
+
+// NOTE: only a very small subset of json path - enough to
+// point at a field, e.g. "name", "$.address.city", "authors.0.id"
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(path, "$")
+	path = strings.TrimPrefix(path, ".")
+	if len(path) == 0 {
+		return []string{}
+	}
+	return strings.Split(path, ".")
+}
+
+// walks decoded json (map[string]interface{} and []interface{})
+func lookupPath(doc interface{}, path string) (interface{}, bool) {
+	current := doc
+	for _, segment := range splitPath(path) {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[segment]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			idx, err := strconv.Atoi(segment)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			current = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// creates intermediate objects as needed (no array support)
+func setPath(doc map[string]interface{}, path string, value interface{}) {
+	segments := splitPath(path)
+	if len(segments) == 0 {
+		return
+	}
+	current := doc
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := current[segment].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[segment] = next
+		}
+		current = next
+	}
+	current[segments[len(segments)-1]] = value
+}
+
+// removes whatever path points at - "*" matches every element
+// of an array (or every key of an object) e.g. "authors.*.fetched"
+func deletePath(doc interface{}, path string) {
+	deleteSegments(doc, splitPath(path))
+}
+
+func deleteSegments(node interface{}, segments []string) {
+	if len(segments) == 0 {
+		return
+	}
+	segment, rest := segments[0], segments[1:]
+	switch current := node.(type) {
+	case map[string]interface{}:
+		if segment == "*" {
+			for key, value := range current {
+				if len(rest) == 0 {
+					delete(current, key)
+				} else {
+					deleteSegments(value, rest)
+				}
+			}
+			return
+		}
+		if len(rest) == 0 {
+			delete(current, segment)
+			return
+		}
+		if value, ok := current[segment]; ok {
+			deleteSegments(value, rest)
+		}
+	case []interface{}:
+		// NOTE: array elements are never removed (indexes would shift)
+		// only things inside them
+		if len(rest) == 0 {
+			return
+		}
+		if segment == "*" {
+			for _, value := range current {
+				deleteSegments(value, rest)
+			}
+			return
+		}
+		idx, err := strconv.Atoi(segment)
+		if err == nil && idx >= 0 && idx < len(current) {
+			deleteSegments(current[idx], rest)
+		}
+	}
+}
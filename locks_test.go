@@ -0,0 +1,45 @@
+package scramjet_test
+
+import (
+	"testing"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+func TestTypeLockRejectsOverlappingRun(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+
+	sj.GetConfig().Locking = sj.LockTry
+	defer func() { sj.GetConfig().Locking = sj.LockNone }()
+
+	// pretend another run has it
+	lock, err := sj.LockTypes(typeName)
+	if err != nil {
+		t.Fatalf("err=%v\n", err)
+	}
+
+	holders, err := sj.LockHolders(typeName)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(holders) != 1 || holders[0].TypeName != typeName {
+		t.Errorf("should be 1 holder of %s - not %v\n", typeName, holders)
+	}
+
+	alwaysOkay := func(json string) bool { return true }
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if _, locked := err.(sj.TypeLockedError); !locked {
+		t.Errorf("transfer should be rejected while locked - err=%v\n", err)
+	}
+
+	err = lock.Unlock()
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("transfer should work once unlocked - err=%v\n", err)
+	}
+}
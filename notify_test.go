@@ -0,0 +1,54 @@
+package scramjet_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+func TestChangeNotifications(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+
+	err := sj.EnableChangeNotifications()
+	if err != nil {
+		t.Fatalf("err=%v\n", err)
+	}
+	defer sj.DisableChangeNotifications()
+	if !sj.ChangeNotificationsEnabled() {
+		t.Errorf("change notifications should be enabled\n")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events, err := sj.ListenForChanges(ctx, sj.ChangeListenerConfig{
+		TypeNames: []string{typeName},
+		Buffer:    10,
+	})
+	if err != nil {
+		t.Fatalf("err=%v\n", err)
+	}
+
+	alwaysOkay := func(json string) bool { return true }
+	person1 := TestPerson{Id: "per0000001", Name: "Test1"}
+	err = sj.StashStaging(sj.MakePacket(person1.Id, typeName, person1))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	select {
+	case event := <-events:
+		if event.Id != person1.Id || event.Op != sj.AddOp {
+			t.Errorf("unexpected change event %v\n", event)
+		}
+	case <-ctx.Done():
+		t.Errorf("never received change event\n")
+	}
+}
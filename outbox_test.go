@@ -0,0 +1,142 @@
+package scramjet_test
+
+import (
+	"context"
+	"testing"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+func TestOutboxClaimAndAck(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	sj.ClearOutbox()
+	typeName := "person"
+
+	sj.GetConfig().UseOutbox = true
+	defer func() { sj.GetConfig().UseOutbox = false }()
+
+	alwaysOkay := func(json string) bool { return true }
+	person1 := TestPerson{Id: "per0000001", Name: "Test1"}
+	person2 := TestPerson{Id: "per0000002", Name: "Test2"}
+	err := sj.StashStaging(sj.MakePacket(person1.Id, typeName, person1),
+		sj.MakePacket(person2.Id, typeName, person2))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.RemoveRecords(sj.MakeStub(person2.Id, typeName))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	claim, err := sj.ClaimOutbox("indexer", 10)
+	if err != nil {
+		t.Fatalf("err=%v\n", err)
+	}
+	if len(claim.Entries) != 3 {
+		t.Fatalf("should have claimed 3 entries - not %d\n", len(claim.Entries))
+	}
+	last := claim.Entries[2]
+	if last.Op != sj.DeleteOp || last.Id != person2.Id || last.OldHash == "" {
+		t.Errorf("unexpected outbox entry %v\n", last)
+	}
+	err = claim.Ack()
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	claim, err = sj.ClaimOutbox("indexer", 10)
+	if err != nil {
+		t.Fatalf("err=%v\n", err)
+	}
+	if len(claim.Entries) != 0 {
+		t.Errorf("should be nothing left to claim - not %d\n", len(claim.Entries))
+	}
+	claim.Release()
+
+	offset, _ := sj.OutboxOffset("indexer")
+	if offset != last.Seq {
+		t.Errorf("offset should be %d - not %d\n", last.Seq, offset)
+	}
+
+	// another consumer still sees everything
+	other, err := sj.ClaimOutbox("notifier", 10)
+	if err != nil {
+		t.Fatalf("err=%v\n", err)
+	}
+	if len(other.Entries) != 3 {
+		t.Errorf("other consumer should claim 3 entries - not %d\n", len(other.Entries))
+	}
+	other.Release()
+}
+
+func TestOutboxOutOfOrderCommit(t *testing.T) {
+	sj.ClearOutbox()
+	db := sj.GetPool()
+	ctx := context.Background()
+	insert := `INSERT INTO outbox (type, id, op, new_hash) VALUES ($1, $2, 'add', 'abc')`
+
+	// NOTE: first takes the lower seq but commits after second
+	first, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatalf("err=%v\n", err)
+	}
+	defer first.Rollback(ctx)
+	_, err = first.Exec(ctx, insert, "person", "per0000001")
+	if err != nil {
+		t.Fatalf("err=%v\n", err)
+	}
+	second, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatalf("err=%v\n", err)
+	}
+	_, err = second.Exec(ctx, insert, "person", "per0000002")
+	if err != nil {
+		t.Fatalf("err=%v\n", err)
+	}
+	err = second.Commit(ctx)
+	if err != nil {
+		t.Fatalf("err=%v\n", err)
+	}
+
+	claim, err := sj.ClaimOutbox("indexer", 10)
+	if err != nil {
+		t.Fatalf("err=%v\n", err)
+	}
+	if len(claim.Entries) != 1 || claim.Entries[0].Id != "per0000002" {
+		t.Fatalf("should only see per0000002 - not %v\n", claim.Entries)
+	}
+	err = claim.Ack()
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	// nothing to prune yet - per0000001 isn't acked
+	_, err = sj.PruneOutbox()
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	err = first.Commit(ctx)
+	if err != nil {
+		t.Fatalf("err=%v\n", err)
+	}
+	claim, err = sj.ClaimOutbox("indexer", 10)
+	if err != nil {
+		t.Fatalf("err=%v\n", err)
+	}
+	if len(claim.Entries) != 1 || claim.Entries[0].Id != "per0000001" {
+		t.Errorf("the lower seq committed later should still be claimed - not %v\n", claim.Entries)
+	}
+	err = claim.Ack()
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	removed, err := sj.PruneOutbox()
+	if err != nil || removed != 1 {
+		t.Errorf("should prune per0000001 - not %d (err=%v)\n", removed, err)
+	}
+}
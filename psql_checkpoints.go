@@ -0,0 +1,144 @@
+package scramjet
+
+import (
+	"context"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// offsets of the chunks (of ChunkSize) already staged under the
+// config's Checkpoint
+func RetrieveIntakeCheckpoints(ins IntakeConfig) ([]int, error) {
+	db := GetPool()
+	ctx := context.Background()
+	offsets := []int{}
+
+	sql := `SELECT chunk_offset
+	  FROM intake_checkpoints
+	  WHERE name = $1 AND type = $2 AND chunk_size = $3
+	  AND token IS NULL
+	  ORDER BY chunk_offset`
+	rows, err := db.Query(ctx, sql, ins.Checkpoint, ins.TypeName, ins.ChunkSize)
+	if err != nil {
+		return offsets, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var offset int
+		err = rows.Scan(&offset)
+		if err != nil {
+			return offsets, errors.Wrap(err, "cannot scan in checkpoint")
+		}
+		offsets = append(offsets, offset)
+	}
+	return offsets, rows.Err()
+}
+
+func recordIntakeCheckpoint(ins IntakeConfig, offset int) error {
+	db := GetPool()
+	ctx := context.Background()
+	sql := `INSERT INTO intake_checkpoints (name, type, chunk_offset, chunk_size)
+	  VALUES ($1, $2, $3, $4)
+	  ON CONFLICT (name, type, chunk_offset, chunk_size)
+	  DO UPDATE SET completed_at = NOW()`
+	_, err := db.Exec(ctx, sql, ins.Checkpoint, ins.TypeName, offset, ins.ChunkSize)
+	if err != nil {
+		return errors.Wrap(err, "recording checkpoint")
+	}
+	return nil
+}
+
+// pages done, and the token for the next one (see eachSourcePage)
+// NOTE: kept as chunk_offset (pages done) with chunk_size 0
+func retrieveTokenCheckpoint(ins IntakeConfig) (int, string, bool, error) {
+	db := GetPool()
+	ctx := context.Background()
+	sql := `SELECT chunk_offset, token
+	  FROM intake_checkpoints
+	  WHERE name = $1 AND type = $2 AND chunk_size = 0
+	  AND token IS NOT NULL
+	  ORDER BY chunk_offset DESC
+	  LIMIT 1`
+	var page int
+	var token string
+	err := db.QueryRow(ctx, sql, ins.Checkpoint, ins.TypeName).Scan(&page, &token)
+	if err == pgx.ErrNoRows {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, errors.Wrap(err, "retrieving token checkpoint")
+	}
+	return page, token, true, nil
+}
+
+func recordTokenCheckpoint(ins IntakeConfig, page int, token string) error {
+	db := GetPool()
+	ctx := context.Background()
+	sql := `INSERT INTO intake_checkpoints (name, type, chunk_offset, chunk_size, token)
+	  VALUES ($1, $2, $3, 0, $4)
+	  ON CONFLICT (name, type, chunk_offset, chunk_size)
+	  DO UPDATE SET token = $4, completed_at = NOW()`
+	_, err := db.Exec(ctx, sql, ins.Checkpoint, ins.TypeName, page, token)
+	if err != nil {
+		return errors.Wrap(err, "recording checkpoint")
+	}
+	return nil
+}
+
+// starts the type over next time
+func ClearIntakeCheckpoints(name string, typeName string) error {
+	db := GetPool()
+	ctx := context.Background()
+	sql := `DELETE FROM intake_checkpoints WHERE name = $1 AND type = $2`
+	_, err := db.Exec(ctx, sql, name, typeName)
+	return err
+}
+
+func IntakeCheckpointsTableExists() bool {
+	var exists bool
+	ctx := context.Background()
+	db := GetPool()
+
+	catalog := GetDbName()
+	sqlExists := `SELECT EXISTS (
+        SELECT 1
+        FROM   information_schema.tables
+        WHERE  table_catalog = $1
+        AND    table_name = 'intake_checkpoints'
+    )`
+	err := db.QueryRow(ctx, sqlExists, catalog).Scan(&exists)
+	if err != nil {
+		log.Fatalf("error checking if row exists %v", err)
+	}
+	return exists
+}
+
+/* NOTE: this calls Fatalf with errors */
+func MakeIntakeCheckpointsSchema() {
+	sql := `create table intake_checkpoints (
+        name text NOT NULL,
+        type text NOT NULL,
+        chunk_offset integer NOT NULL,
+        chunk_size integer NOT NULL,
+        token text,
+        completed_at TIMESTAMP DEFAULT NOW(),
+        PRIMARY KEY(name, type, chunk_offset, chunk_size)
+    )`
+	ctx := context.Background()
+	db := GetPool()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Fatalf(">error beginning transaction:%v", err)
+	}
+	_, err = tx.Exec(ctx, sql)
+	if err != nil {
+		log.Fatalf(">error executing sql:%v", err)
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Fatalf("ERROR(CREATE):%v", err)
+	}
+}
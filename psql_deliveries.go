@@ -0,0 +1,236 @@
+package scramjet
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// a change a sink could not take - kept until RetryDeliveries works
+type FailedDelivery struct {
+	Sink      string
+	Change    Change
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// NOTE: one row per sink and resource - a later failure for the same
+// resource brings the latest state, but previous_* stay what the sink
+// last got (an add stays an add, and a delete of one removes the row)
+func RecordFailedDeliveries(sinkName string, cause error, changes ...Change) error {
+	db := GetPool()
+	ctx := context.Background()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	// supposedly no-op if everything okay
+	defer tx.Rollback(ctx)
+
+	// NOTE: a patch only goes from the previous version, so once two
+	// failures are combined it's dropped (data is still the latest)
+	sql := `INSERT INTO sink_deliveries (sink, id, type, op, hash, previous_hash,
+	    data, previous_data, patch, last_error)
+	  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	  ON CONFLICT (sink, id, type) DO UPDATE SET
+	    op = CASE
+	      WHEN sink_deliveries.op = 'add' THEN 'add'
+	      WHEN sink_deliveries.op = 'delete' AND EXCLUDED.op = 'add' THEN 'update'
+	      ELSE EXCLUDED.op END,
+	    hash = EXCLUDED.hash,
+	    data = EXCLUDED.data,
+	    patch = NULL,
+	    last_error = EXCLUDED.last_error,
+	    attempts = sink_deliveries.attempts + 1,
+	    updated_at = NOW()
+	`
+	// the sink never got it, so there's nothing to delete
+	neverSent := `DELETE FROM sink_deliveries
+	  WHERE sink = $1 AND id = $2 AND type = $3 AND op = 'add'`
+	for _, change := range changes {
+		if change.Op == DeleteOp {
+			tag, err := tx.Exec(ctx, neverSent, sinkName, change.Id.Id, change.Id.Type)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("recording failed delivery %s", change.Id))
+			}
+			if tag.RowsAffected() > 0 {
+				continue
+			}
+		}
+		_, err = tx.Exec(ctx, sql, sinkName, change.Id.Id, change.Id.Type, string(change.Op),
+			change.Hash, change.PreviousHash, nullableJSON(change.Data),
+			nullableJSON(change.PreviousData), patchColumn(change.Patch), cause.Error())
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("recording failed delivery %s", change.Id))
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return errors.Wrap(err, "commit transaction")
+	}
+	return nil
+}
+
+// json columns want NULL instead of empty
+func nullableJSON(data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return data
+}
+
+func RetrieveFailedDeliveries(sinkName string) ([]FailedDelivery, error) {
+	db := GetPool()
+	ctx := context.Background()
+	deliveries := []FailedDelivery{}
+
+	sql := `SELECT sink, id, type, op, coalesce(hash, ''), coalesce(previous_hash, ''),
+	  data, previous_data, patch, attempts, coalesce(last_error, ''), created_at, updated_at
+	  FROM sink_deliveries
+	  WHERE sink = $1
+	  ORDER BY created_at`
+
+	rows, err := db.Query(ctx, sql, sinkName)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var delivery FailedDelivery
+		var op string
+		var patch []byte
+		change := Change{}
+		err = rows.Scan(&delivery.Sink, &change.Id.Id, &change.Id.Type, &op,
+			&change.Hash, &change.PreviousHash, &change.Data, &change.PreviousData,
+			&patch, &delivery.Attempts, &delivery.LastError, &delivery.CreatedAt, &delivery.UpdatedAt)
+		if err != nil {
+			return deliveries, errors.Wrap(err, "could not read failed delivery")
+		}
+		change.Op = ChangeOp(op)
+		change.Patch = readPatchColumn(patch)
+		delivery.Change = change
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func ClearDeliveries(sinkName string, changes ...Change) error {
+	db := GetPool()
+	ctx := context.Background()
+
+	ids := make([]Identifiable, 0)
+	for _, change := range changes {
+		ids = append(ids, change)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// noop if no problems
+	defer tx.Rollback(ctx)
+
+	for _, chunk := range chunked(ids, 500) {
+		inSQL, args := "", []interface{}{sinkName}
+		for i, resource := range chunk {
+			n := i*2 + 1
+			inSQL += fmt.Sprintf("($%d,$%d),", n+1, n+2)
+			args = append(args, resource.Identifier().Id, resource.Identifier().Type)
+		}
+		inSQL = inSQL[:len(inSQL)-1] // drop last ","
+
+		sql := `DELETE from sink_deliveries WHERE sink = $1 AND (id, type) IN (` + inSQL + `)`
+		_, err = tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return errors.Wrap(err, "clearing deliveries")
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+	return nil
+}
+
+func FailedDeliveryCount(sinkName string) int {
+	var count int
+	ctx := context.Background()
+	sql := `SELECT count(*)
+	FROM sink_deliveries
+	WHERE sink = $1`
+	db := GetPool()
+	row := db.QueryRow(ctx, sql, sinkName)
+	err := row.Scan(&count)
+	if err != nil {
+		log.Fatalf("error checking count %v", err)
+	}
+	return count
+}
+
+func SinkDeliveryTableExists() bool {
+	var exists bool
+	ctx := context.Background()
+	db := GetPool()
+
+	catalog := GetDbName()
+	sqlExists := `SELECT EXISTS (
+        SELECT 1
+        FROM   information_schema.tables
+        WHERE  table_catalog = $1
+        AND    table_name = 'sink_deliveries'
+    )`
+	err := db.QueryRow(ctx, sqlExists, catalog).Scan(&exists)
+	if err != nil {
+		log.Fatalf("error checking if row exists %v", err)
+	}
+	return exists
+}
+
+/* NOTE: this calls Fatalf with errors */
+func MakeSinkDeliverySchema() {
+	sql := `create table sink_deliveries (
+        sink text NOT NULL,
+        id text NOT NULL,
+        type text NOT NULL,
+        op text NOT NULL,
+        hash text,
+        previous_hash text,
+        data json,
+        previous_data json,
+        patch json,
+        attempts integer DEFAULT 1,
+        last_error text,
+        created_at TIMESTAMP DEFAULT NOW(),
+        updated_at TIMESTAMP DEFAULT NOW(),
+        PRIMARY KEY(sink, id, type)
+    )`
+	ctx := context.Background()
+	db := GetPool()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Fatalf(">error beginning transaction:%v", err)
+	}
+	_, err = tx.Exec(ctx, sql)
+	if err != nil {
+		log.Fatalf(">error executing sql:%v", err)
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Fatalf("ERROR(CREATE):%v", err)
+	}
+}
+
+func ClearAllDeliveries() error {
+	db := GetPool()
+	ctx := context.Background()
+	_, err := db.Exec(ctx, `DELETE from sink_deliveries`)
+	return err
+}
@@ -0,0 +1,136 @@
+package scramjet
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// a record that was not staged because a transform failed (see
+// RegisterTransform) - Id.Id is "" if it didn't have one yet
+type IntakeError struct {
+	Id         Identifier
+	Transform  string
+	Reason     string
+	Data       json.RawMessage
+	RecordedAt time.Time
+}
+
+func (e IntakeError) Identifier() Identifier {
+	return e.Id
+}
+
+func recordIntakeErrors(failed []IntakeError) error {
+	db := GetPool()
+	ctx := context.Background()
+	ids, types, names, reasons, data := []string{}, []string{}, []string{}, []string{}, []*string{}
+	for _, intakeError := range failed {
+		ids = append(ids, intakeError.Id.Id)
+		types = append(types, intakeError.Id.Type)
+		names = append(names, intakeError.Transform)
+		reasons = append(reasons, intakeError.Reason)
+		if len(intakeError.Data) == 0 {
+			data = append(data, nil)
+		} else {
+			str := string(intakeError.Data)
+			data = append(data, &str)
+		}
+	}
+	sql := `INSERT INTO intake_errors (id, type, transform, reason, data)
+	  SELECT r.id, r.type, r.transform, r.reason, r.data::json
+	  FROM unnest($1::text[], $2::text[], $3::text[], $4::text[], $5::text[])
+	  AS r(id, type, transform, reason, data)`
+	_, err := db.Exec(ctx, sql, ids, types, names, reasons, data)
+	if err != nil {
+		return errors.Wrap(err, "recording intake errors")
+	}
+	return nil
+}
+
+// all types if typeName is ""
+func RetrieveIntakeErrors(typeName string) ([]IntakeError, error) {
+	db := GetPool()
+	ctx := context.Background()
+	failed := []IntakeError{}
+
+	sql := `SELECT id, type, transform, reason, data::text, recorded_at
+	  FROM intake_errors
+	  WHERE ($1 = '' OR type = $1)
+	  ORDER BY recorded_at, type, id`
+	rows, err := db.Query(ctx, sql, typeName)
+	if err != nil {
+		return failed, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var intakeError IntakeError
+		var data *string
+		err = rows.Scan(&intakeError.Id.Id, &intakeError.Id.Type, &intakeError.Transform,
+			&intakeError.Reason, &data, &intakeError.RecordedAt)
+		if err != nil {
+			return failed, errors.Wrap(err, "cannot scan in intake error")
+		}
+		if data != nil {
+			intakeError.Data = json.RawMessage(*data)
+		}
+		failed = append(failed, intakeError)
+	}
+	return failed, rows.Err()
+}
+
+// all types if typeName is ""
+func ClearIntakeErrors(typeName string) error {
+	db := GetPool()
+	ctx := context.Background()
+	_, err := db.Exec(ctx, `DELETE FROM intake_errors WHERE ($1 = '' OR type = $1)`, typeName)
+	return err
+}
+
+func IntakeErrorsTableExists() bool {
+	var exists bool
+	ctx := context.Background()
+	db := GetPool()
+
+	catalog := GetDbName()
+	sqlExists := `SELECT EXISTS (
+        SELECT 1
+        FROM   information_schema.tables
+        WHERE  table_catalog = $1
+        AND    table_name = 'intake_errors'
+    )`
+	err := db.QueryRow(ctx, sqlExists, catalog).Scan(&exists)
+	if err != nil {
+		log.Fatalf("error checking if row exists %v", err)
+	}
+	return exists
+}
+
+/* NOTE: this calls Fatalf with errors */
+func MakeIntakeErrorsSchema() {
+	sql := `create table intake_errors (
+        id text NOT NULL,
+        type text NOT NULL,
+        transform text NOT NULL,
+        reason text NOT NULL,
+        data json,
+        recorded_at TIMESTAMP DEFAULT NOW()
+    )`
+	ctx := context.Background()
+	db := GetPool()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Fatalf(">error beginning transaction:%v", err)
+	}
+	_, err = tx.Exec(ctx, sql)
+	if err != nil {
+		log.Fatalf(">error executing sql:%v", err)
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Fatalf("ERROR(CREATE):%v", err)
+	}
+}
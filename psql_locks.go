@@ -0,0 +1,202 @@
+package scramjet
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pkg/errors"
+)
+
+// how pipeline functions (Scramjet, TransferAll etc...) wait
+// for another run of the same type
+type LockMode int
+
+const (
+	LockNone     LockMode = iota // no locking (default)
+	LockBlocking                 // wait as long as it takes
+	LockTry                      // fail right away with TypeLockedError
+	LockTimeout                  // wait up to Config.LockTimeout
+)
+
+// first key of pg_advisory_lock(int, int) - second is hashtext(type)
+const advisoryLockClass = 0x534a
+
+// how often to try again in LockTimeout mode
+var lockRetryInterval = 100 * time.Millisecond
+
+type TypeLockedError struct {
+	TypeName string
+}
+
+func (e TypeLockedError) Error() string {
+	return fmt.Sprintf("%s is locked by another scramjet run", e.TypeName)
+}
+
+// NOTE: session level advisory locks - they belong to the connection,
+// so it's held (out of the pool) until Unlock
+type TypeLock struct {
+	TypeNames []string
+	conn      *pgxpool.Conn
+}
+
+func (l *TypeLock) Unlock() error {
+	if l == nil || l.conn == nil {
+		return nil
+	}
+	ctx := context.Background()
+	defer l.conn.Release()
+	for _, typeName := range l.TypeNames {
+		_, err := l.conn.Exec(ctx, `SELECT pg_advisory_unlock($1, hashtext($2))`,
+			advisoryLockClass, typeName)
+		if err != nil {
+			// NOTE: connection is suspect - don't let it back in the pool
+			l.conn.Conn().Close(ctx)
+			return errors.Wrap(err, fmt.Sprintf("unlocking %s", typeName))
+		}
+	}
+	l.conn = nil
+	return nil
+}
+
+func lockMode() (LockMode, time.Duration) {
+	conf := GetConfig()
+	if conf == nil {
+		return LockNone, 0
+	}
+	return conf.Locking, conf.LockTimeout
+}
+
+// locks all the types (in the same order every time, to avoid deadlocks)
+// using Config.Locking - with LockNone it does nothing
+// NOTE: needs a connection of its own (MaxConnections > 1)
+func LockTypes(typeNames ...string) (*TypeLock, error) {
+	mode, timeout := lockMode()
+	if mode == LockNone {
+		return &TypeLock{}, nil
+	}
+
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, typeName := range typeNames {
+		if typeName == "" || seen[typeName] {
+			continue
+		}
+		seen[typeName] = true
+		names = append(names, typeName)
+	}
+	sort.Strings(names)
+
+	ctx := context.Background()
+	// NOTE: Configure checks this too - but Locking can be turned on after
+	if GetPool().Config().MaxConns < 2 {
+		return nil, errors.New("locking needs a pool of at least 2 connections")
+	}
+	conn, err := GetPool().Acquire(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "acquiring connection to lock")
+	}
+	lock := &TypeLock{conn: conn}
+	for _, typeName := range names {
+		err = lockType(ctx, conn, typeName, mode, timeout)
+		if err != nil {
+			// give back the ones already held (and the connection)
+			lock.Unlock()
+			return nil, err
+		}
+		lock.TypeNames = append(lock.TypeNames, typeName)
+	}
+	return lock, nil
+}
+
+func lockType(ctx context.Context, conn *pgxpool.Conn, typeName string, mode LockMode, timeout time.Duration) error {
+	if mode == LockBlocking {
+		_, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1, hashtext($2))`,
+			advisoryLockClass, typeName)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("locking %s", typeName))
+		}
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var locked bool
+		err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1, hashtext($2))`,
+			advisoryLockClass, typeName).Scan(&locked)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("locking %s", typeName))
+		}
+		if locked {
+			return nil
+		}
+		if mode == LockTry || time.Now().After(deadline) {
+			return TypeLockedError{TypeName: typeName}
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// who has a type locked (from pg_locks and pg_stat_activity)
+type LockHolder struct {
+	TypeName    string
+	Pid         int
+	Application string
+	ClientAddr  string
+	Since       time.Time // when the holder's session started
+	State       string
+}
+
+// NOTE: advisory locks only store hashtext(type) - so the names have
+// to be sent in (defaults to every type in staging or resources)
+func LockHolders(typeNames ...string) ([]LockHolder, error) {
+	db := GetPool()
+	ctx := context.Background()
+	holders := []LockHolder{}
+
+	if len(typeNames) == 0 {
+		rows, err := db.Query(ctx, `SELECT DISTINCT type FROM staging
+		  UNION SELECT DISTINCT type FROM resources`)
+		if err != nil {
+			return holders, err
+		}
+		for rows.Next() {
+			var typeName string
+			err = rows.Scan(&typeName)
+			if err != nil {
+				rows.Close()
+				return holders, err
+			}
+			typeNames = append(typeNames, typeName)
+		}
+		rows.Close()
+	}
+
+	sql := `SELECT t.type, l.pid, coalesce(a.application_name, ''),
+	    coalesce(host(a.client_addr), ''), a.backend_start, coalesce(a.state, '')
+	  FROM unnest($1::text[]) AS t(type)
+	  JOIN pg_locks l ON (l.locktype = 'advisory'
+	    AND l.classid = $2::int::oid
+	    AND l.objid = hashtext(t.type)::oid
+	    AND l.objsubid = 2
+	    AND l.granted)
+	  JOIN pg_stat_activity a ON (a.pid = l.pid)
+	  ORDER BY t.type`
+	rows, err := db.Query(ctx, sql, typeNames, advisoryLockClass)
+	if err != nil {
+		return holders, errors.Wrap(err, "looking up lock holders")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var holder LockHolder
+		err = rows.Scan(&holder.TypeName, &holder.Pid, &holder.Application,
+			&holder.ClientAddr, &holder.Since, &holder.State)
+		if err != nil {
+			return holders, errors.Wrap(err, "cannot scan in lock holder")
+		}
+		holders = append(holders, holder)
+	}
+	return holders, rows.Err()
+}
@@ -0,0 +1,199 @@
+package scramjet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pkg/errors"
+)
+
+// what comes through pg_notify for every insert, update (only if
+// hash changed) and delete on resources
+type ChangeEvent struct {
+	Id   string   `json:"id"`
+	Type string   `json:"type"`
+	Op   ChangeOp `json:"op"`
+	Hash string   `json:"hash"`
+}
+
+func (e ChangeEvent) Identifier() Identifier {
+	return Identifier{e.Id, e.Type}
+}
+
+// one channel per type e.g. "scramjet_person"
+func NotifyChannel(typeName string) string {
+	return fmt.Sprintf("scramjet_%s", typeName)
+}
+
+// NOTE: this is opt-in (see Config.NotifyChanges) - it adds a row
+// level trigger to resources
+func EnableChangeNotifications() error {
+	function := `CREATE OR REPLACE FUNCTION scramjet_notify_change() RETURNS TRIGGER AS $scramjet_notify$
+    DECLARE
+      rec RECORD;
+      op text;
+    BEGIN
+      IF TG_OP = 'DELETE' THEN
+        rec := OLD;
+        op := 'delete';
+      ELSIF TG_OP = 'INSERT' THEN
+        rec := NEW;
+        op := 'add';
+      ELSE
+        IF NEW.hash = OLD.hash THEN
+          RETURN NULL;
+        END IF;
+        rec := NEW;
+        op := 'update';
+      END IF;
+      PERFORM pg_notify('scramjet_' || rec.type,
+        json_build_object('id', rec.id, 'type', rec.type, 'op', op, 'hash', rec.hash)::text);
+      RETURN NULL; -- result is ignored since this is an AFTER trigger
+    END;
+  $scramjet_notify$ LANGUAGE plpgsql`
+
+	trigger := `CREATE TRIGGER scramjet_notify
+    AFTER INSERT OR UPDATE OR DELETE ON resources
+    FOR EACH ROW EXECUTE PROCEDURE scramjet_notify_change()`
+
+	db := GetPool()
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// noop if no problems
+	defer tx.Rollback(ctx)
+
+	for _, sql := range []string{function,
+		`DROP TRIGGER IF EXISTS scramjet_notify ON resources`,
+		trigger} {
+		_, err = tx.Exec(ctx, sql)
+		if err != nil {
+			return errors.Wrap(err, "creating notify trigger")
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func DisableChangeNotifications() error {
+	db := GetPool()
+	ctx := context.Background()
+	_, err := db.Exec(ctx, `DROP TRIGGER IF EXISTS scramjet_notify ON resources`)
+	return err
+}
+
+func ChangeNotificationsEnabled() bool {
+	var exists bool
+	db := GetPool()
+	ctx := context.Background()
+	sql := `SELECT EXISTS (
+	    SELECT 1 FROM pg_trigger
+	    WHERE tgname = 'scramjet_notify'
+	    AND tgrelid = 'resources'::regclass
+	)`
+	err := db.QueryRow(ctx, sql).Scan(&exists)
+	if err != nil {
+		return false
+	}
+	return exists
+}
+
+type ChangeListenerConfig struct {
+	TypeNames      []string
+	ReconnectDelay time.Duration // default 1 second
+	Buffer         int           // size of the events channel
+}
+
+// NOTE: holds one pool connection for as long as ctx is alive, so
+// the pool needs room for it (MaxConnections > 1) - also notifications
+// sent while reconnecting are missed, for guaranteed delivery see the outbox
+func ListenForChanges(ctx context.Context, config ChangeListenerConfig) (<-chan ChangeEvent, error) {
+	if len(config.TypeNames) == 0 {
+		return nil, errors.New("no types to listen for")
+	}
+	conn, err := startListening(ctx, config.TypeNames)
+	if err != nil {
+		return nil, err
+	}
+	delay := config.ReconnectDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	events := make(chan ChangeEvent, config.Buffer)
+
+	go func() {
+		defer close(events)
+		logger := GetLogger()
+		for {
+			err := waitForChanges(ctx, conn, events)
+			stopListening(conn)
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Info(fmt.Sprintf("change listener lost connection: %s\n", err))
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				conn, err = startListening(ctx, config.TypeNames)
+				if err == nil {
+					break
+				}
+				logger.Info(fmt.Sprintf("change listener could not reconnect: %s\n", err))
+			}
+		}
+	}()
+	return events, nil
+}
+
+func startListening(ctx context.Context, typeNames []string) (*pgxpool.Conn, error) {
+	conn, err := GetPool().Acquire(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "acquiring connection to listen")
+	}
+	for _, typeName := range typeNames {
+		channel := pgx.Identifier{NotifyChannel(typeName)}.Sanitize()
+		_, err = conn.Exec(ctx, "LISTEN "+channel)
+		if err != nil {
+			conn.Release()
+			return nil, errors.Wrap(err, fmt.Sprintf("listening to %s", channel))
+		}
+	}
+	return conn, nil
+}
+
+func stopListening(conn *pgxpool.Conn) {
+	// NOTE: connection goes back to pool - don't leave it listening
+	// (fails harmlessly if connection is already gone)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn.Exec(ctx, "UNLISTEN *")
+	conn.Release()
+}
+
+func waitForChanges(ctx context.Context, conn *pgxpool.Conn, events chan<- ChangeEvent) error {
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var event ChangeEvent
+		err = json.Unmarshal([]byte(notification.Payload), &event)
+		if err != nil {
+			GetLogger().Info(fmt.Sprintf("unreadable change notification %s\n", notification.Payload))
+			continue
+		}
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package scramjet
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// a child resource whose parent was deleted (see FlagOrphan and FindOrphans)
+type ResourceOrphan struct {
+	Id         Identifier
+	ParentType string
+	ParentId   string
+	Field      string
+	FlaggedAt  time.Time
+}
+
+func (o ResourceOrphan) Identifier() Identifier {
+	return o.Id
+}
+
+// all types if typeName is ""
+func RetrieveOrphans(typeName string) ([]ResourceOrphan, error) {
+	db := GetPool()
+	ctx := context.Background()
+	orphans := []ResourceOrphan{}
+
+	sql := `SELECT id, type, parent_type, parent_id, field, flagged_at
+	  FROM resource_orphans
+	  WHERE ($1 = '' OR type = $1)
+	  ORDER BY type, id`
+	rows, err := db.Query(ctx, sql, typeName)
+	if err != nil {
+		return orphans, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var orphan ResourceOrphan
+		err = rows.Scan(&orphan.Id.Id, &orphan.Id.Type, &orphan.ParentType,
+			&orphan.ParentId, &orphan.Field, &orphan.FlaggedAt)
+		if err != nil {
+			return orphans, errors.Wrap(err, "cannot scan in orphan")
+		}
+		orphans = append(orphans, orphan)
+	}
+	return orphans, rows.Err()
+}
+
+// once a resource is deleted it's no longer an orphan
+func clearOrphans(ctx context.Context, tx pgx.Tx, deleted []Change) error {
+	if len(deleted) == 0 {
+		return nil
+	}
+	ids, types := []string{}, []string{}
+	for _, change := range deleted {
+		ids = append(ids, change.Id.Id)
+		types = append(types, change.Id.Type)
+	}
+	sql := `DELETE FROM resource_orphans o
+	  USING unnest($1::text[], $2::text[]) AS d(id, type)
+	  WHERE o.id = d.id AND o.type = d.type`
+	_, err := tx.Exec(ctx, sql, ids, types)
+	if err != nil {
+		return errors.Wrap(err, "clearing orphans")
+	}
+	return nil
+}
+
+func ClearAllOrphans() error {
+	db := GetPool()
+	ctx := context.Background()
+	_, err := db.Exec(ctx, `DELETE FROM resource_orphans`)
+	return err
+}
+
+func ResourceOrphansTableExists() bool {
+	var exists bool
+	ctx := context.Background()
+	db := GetPool()
+
+	catalog := GetDbName()
+	sqlExists := `SELECT EXISTS (
+        SELECT 1
+        FROM   information_schema.tables
+        WHERE  table_catalog = $1
+        AND    table_name = 'resource_orphans'
+    )`
+	err := db.QueryRow(ctx, sqlExists, catalog).Scan(&exists)
+	if err != nil {
+		log.Fatalf("error checking if row exists %v", err)
+	}
+	return exists
+}
+
+/* NOTE: this calls Fatalf with errors */
+func MakeResourceOrphansSchema() {
+	sql := `create table resource_orphans (
+        id text NOT NULL,
+        type text NOT NULL,
+        parent_type text NOT NULL,
+        parent_id text NOT NULL,
+        field text NOT NULL,
+        flagged_at TIMESTAMP DEFAULT NOW(),
+        PRIMARY KEY(id, type, parent_type, field)
+    )`
+	ctx := context.Background()
+	db := GetPool()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Fatalf(">error beginning transaction:%v", err)
+	}
+	_, err = tx.Exec(ctx, sql)
+	if err != nil {
+		log.Fatalf(">error executing sql:%v", err)
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Fatalf("ERROR(CREATE):%v", err)
+	}
+}
+
+// child resources (of each relationship - or all registered ones) whose
+// parent isn't in resources, whatever OnDelete says
+// NOTE: same as deletes - with ManyToMany it's only an orphan if none
+// of the parents are there (ParentId is one of the missing ones)
+func FindOrphans(relationships ...Relationship) ([]ResourceOrphan, error) {
+	if len(relationships) == 0 {
+		relationships = GetRelationships()
+	}
+	orphans := []ResourceOrphan{}
+	for _, relationship := range relationships {
+		found, err := findRelationshipOrphans(relationship)
+		if err != nil {
+			return orphans, err
+		}
+		orphans = append(orphans, found...)
+	}
+	return orphans, nil
+}
+
+func findRelationshipOrphans(relationship Relationship) ([]ResourceOrphan, error) {
+	db := GetPool()
+	ctx := context.Background()
+	orphans := []ResourceOrphan{}
+
+	var sql string
+	if relationship.Cardinality == ManyToMany {
+		parents := `jsonb_array_elements_text(CASE WHEN jsonb_typeof(c.data_b #> $2) = 'array'
+		  THEN c.data_b #> $2 ELSE '[]'::jsonb END)`
+		sql = fmt.Sprintf(`SELECT c.id, (SELECT min(o.id) FROM %s AS o(id))
+		  FROM resources c
+		  WHERE c.type = $1
+		  AND EXISTS (SELECT 1 FROM %s AS o(id))
+		  AND NOT EXISTS (
+		    SELECT 1 FROM %s AS o(id)
+		    JOIN resources p ON (p.id = o.id AND p.type = $3)
+		  )
+		  ORDER BY c.id`, parents, parents, parents)
+	} else {
+		sql = `SELECT c.id, c.data_b #>> $2
+		  FROM resources c
+		  WHERE c.type = $1
+		  AND c.data_b #>> $2 IS NOT NULL
+		  AND NOT EXISTS (
+		    SELECT 1 FROM resources p
+		    WHERE p.type = $3
+		    AND p.id = c.data_b #>> $2
+		  )
+		  ORDER BY c.id`
+	}
+	rows, err := db.Query(ctx, sql, relationship.ChildType, splitPath(relationship.Field),
+		relationship.ParentType)
+	if err != nil {
+		return orphans, errors.Wrap(err, fmt.Sprintf("finding %s orphans", relationship.ChildType))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		orphan := ResourceOrphan{ParentType: relationship.ParentType, Field: relationship.Field}
+		orphan.Id.Type = relationship.ChildType
+		err = rows.Scan(&orphan.Id.Id, &orphan.ParentId)
+		if err != nil {
+			return orphans, errors.Wrap(err, "cannot scan in orphan")
+		}
+		orphans = append(orphans, orphan)
+	}
+	return orphans, rows.Err()
+}
+
+// so the next Eject (or BulkRemoveStagingDeletedFromResources)
+// of each type removes them
+func FlagOrphansForDelete(orphans ...ResourceOrphan) error {
+	ids := []Identifiable{}
+	for _, orphan := range orphans {
+		ids = append(ids, orphan)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return BulkAddStagingForDelete(ids...)
+}
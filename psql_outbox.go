@@ -0,0 +1,313 @@
+package scramjet
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// one row per change, written in the same transaction as the change
+// itself (see Config.UseOutbox) - so nothing is lost if the process
+// dies before sinks or listeners hear about it
+type OutboxEntry struct {
+	Seq       int64
+	Type      string
+	Id        string
+	Op        ChangeOp
+	OldHash   string
+	NewHash   string
+	Patch     JSONPatch // with Config.TrackPatches (updates only)
+	CreatedAt time.Time
+}
+
+func (e OutboxEntry) Identifier() Identifier {
+	return Identifier{e.Id, e.Type}
+}
+
+func outboxEnabled() bool {
+	conf := GetConfig()
+	return conf != nil && conf.UseOutbox
+}
+
+// NOTE: tx is the one doing the upsert (or delete) - does nothing
+// unless the outbox is turned on
+func writeOutbox(ctx context.Context, tx pgx.Tx, changes []Change) error {
+	if !outboxEnabled() || len(changes) == 0 {
+		return nil
+	}
+	inputRows := [][]interface{}{}
+	for _, change := range changes {
+		inputRows = append(inputRows, []interface{}{change.Id.Type,
+			change.Id.Id,
+			string(change.Op),
+			nullableText(change.PreviousHash),
+			nullableText(change.Hash),
+			patchColumn(change.Patch)})
+	}
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{"outbox"},
+		[]string{"type", "id", "op", "old_hash", "new_hash", "patch"},
+		pgx.CopyFromRows(inputRows))
+	if err != nil {
+		return errors.Wrap(err, "writing outbox")
+	}
+	return nil
+}
+
+// adds have no old hash, deletes no new one
+func nullableText(text string) interface{} {
+	if text == "" {
+		return nil
+	}
+	return text
+}
+
+// rows a consumer is working on - they stay locked (other workers for
+// the same consumer skip them) until Ack or Release
+type OutboxClaim struct {
+	Consumer string
+	Entries  []OutboxEntry
+	tx       pgx.Tx
+}
+
+// NOTE: more than one worker can claim for the same consumer, each
+// gets different rows (FOR UPDATE SKIP LOCKED) - rows locked by
+// another consumer are skipped too, but only until that one is done.
+// Whatever the consumer hasn't acked is claimable, not just what is
+// past its offset - a transfer can commit a lower seq after a higher one
+func ClaimOutbox(consumer string, limit int) (*OutboxClaim, error) {
+	db := GetPool()
+	ctx := context.Background()
+
+	_, err := db.Exec(ctx, `INSERT INTO outbox_offsets (consumer) VALUES ($1)
+	  ON CONFLICT (consumer) DO NOTHING`, consumer)
+	if err != nil {
+		return nil, errors.Wrap(err, "adding outbox consumer")
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+
+	sql := `SELECT o.seq, o.type, o.id, o.op, coalesce(o.old_hash, ''),
+	    coalesce(o.new_hash, ''), o.patch, o.created_at
+	  FROM outbox o
+	  WHERE NOT EXISTS (
+	    SELECT 1 FROM outbox_acks a
+	    WHERE a.consumer = $1 AND a.seq = o.seq
+	  )
+	  ORDER BY o.seq
+	  LIMIT $2
+	  FOR UPDATE OF o SKIP LOCKED`
+	rows, err := tx.Query(ctx, sql, consumer, limit)
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, errors.Wrap(err, "claiming outbox rows")
+	}
+	entries, err := scanOutboxEntries(rows)
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	return &OutboxClaim{Consumer: consumer, Entries: entries, tx: tx}, nil
+}
+
+func scanOutboxEntries(rows pgx.Rows) ([]OutboxEntry, error) {
+	defer rows.Close()
+	entries := []OutboxEntry{}
+	for rows.Next() {
+		var entry OutboxEntry
+		var op string
+		var patch []byte
+		err := rows.Scan(&entry.Seq, &entry.Type, &entry.Id, &op,
+			&entry.OldHash, &entry.NewHash, &patch, &entry.CreatedAt)
+		if err != nil {
+			return entries, errors.Wrap(err, "cannot scan in outbox entry")
+		}
+		entry.Op = ChangeOp(op)
+		entry.Patch = readPatchColumn(patch)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// marks the claimed rows as done for this consumer (the acks are kept
+// until PruneOutbox removes the rows)
+func (c *OutboxClaim) Ack() error {
+	ctx := context.Background()
+	// noop if no problems
+	defer c.tx.Rollback(ctx)
+
+	if len(c.Entries) > 0 {
+		inputRows := [][]interface{}{}
+		var highest int64
+		for _, entry := range c.Entries {
+			inputRows = append(inputRows, []interface{}{c.Consumer, entry.Seq})
+			if entry.Seq > highest {
+				highest = entry.Seq
+			}
+		}
+		_, err := c.tx.CopyFrom(ctx, pgx.Identifier{"outbox_acks"},
+			[]string{"consumer", "seq"},
+			pgx.CopyFromRows(inputRows))
+		if err != nil {
+			return errors.Wrap(err, "acknowledging outbox rows")
+		}
+
+		sqlOffset := `UPDATE outbox_offsets
+		  SET last_seq = greatest(last_seq, $2), updated_at = NOW()
+		  WHERE consumer = $1`
+		_, err = c.tx.Exec(ctx, sqlOffset, c.Consumer, highest)
+		if err != nil {
+			return errors.Wrap(err, "moving outbox offset")
+		}
+	}
+	err := c.tx.Commit(ctx)
+	if err != nil {
+		return errors.Wrap(err, "commit transaction")
+	}
+	return nil
+}
+
+// gives the rows back (unacknowledged) for the next claim
+func (c *OutboxClaim) Release() error {
+	return c.tx.Rollback(context.Background())
+}
+
+// the highest seq the consumer has acked
+// NOTE: just for information - a lower one can still be waiting (see
+// ClaimOutbox)
+func OutboxOffset(consumer string) (int64, error) {
+	var offset int64
+	db := GetPool()
+	ctx := context.Background()
+	sql := `SELECT last_seq FROM outbox_offsets WHERE consumer = $1`
+	err := db.QueryRow(ctx, sql, consumer).Scan(&offset)
+	if err != nil {
+		return offset, errors.Wrap(err, fmt.Sprintf("finding offset for %s", consumer))
+	}
+	return offset, nil
+}
+
+// removes rows every consumer has acked (and those acks)
+func PruneOutbox() (int64, error) {
+	db := GetPool()
+	ctx := context.Background()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "starting transaction")
+	}
+	// noop if no problems
+	defer tx.Rollback(ctx)
+
+	sql := `DELETE FROM outbox o
+	  WHERE EXISTS (SELECT 1 FROM outbox_offsets)
+	  AND NOT EXISTS (
+	    SELECT 1 FROM outbox_offsets f
+	    WHERE NOT EXISTS (
+	      SELECT 1 FROM outbox_acks a
+	      WHERE a.consumer = f.consumer AND a.seq = o.seq
+	    )
+	  )`
+	tag, err := tx.Exec(ctx, sql)
+	if err != nil {
+		return 0, errors.Wrap(err, "pruning outbox")
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM outbox_acks a
+	  WHERE NOT EXISTS (SELECT 1 FROM outbox o WHERE o.seq = a.seq)`)
+	if err != nil {
+		return 0, errors.Wrap(err, "pruning outbox acks")
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "commit transaction")
+	}
+	return tag.RowsAffected(), nil
+}
+
+func ClearOutbox() error {
+	db := GetPool()
+	ctx := context.Background()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// noop if no problems
+	defer tx.Rollback(ctx)
+
+	for _, sql := range []string{`DELETE from outbox_acks`,
+		`DELETE from outbox_offsets`,
+		`DELETE from outbox`} {
+		_, err = tx.Exec(ctx, sql)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func OutboxTableExists() bool {
+	var exists bool
+	ctx := context.Background()
+	db := GetPool()
+
+	catalog := GetDbName()
+	sqlExists := `SELECT EXISTS (
+        SELECT 1
+        FROM   information_schema.tables
+        WHERE  table_catalog = $1
+        AND    table_name = 'outbox'
+    )`
+	err := db.QueryRow(ctx, sqlExists, catalog).Scan(&exists)
+	if err != nil {
+		log.Fatalf("error checking if row exists %v", err)
+	}
+	return exists
+}
+
+/* NOTE: this calls Fatalf with errors */
+func MakeOutboxSchema() {
+	sqls := []string{`create table outbox (
+        seq bigserial PRIMARY KEY,
+        type text NOT NULL,
+        id text NOT NULL,
+        op text NOT NULL,
+        old_hash text,
+        new_hash text,
+        patch json,
+        created_at TIMESTAMP DEFAULT NOW()
+    )`,
+		`create table outbox_offsets (
+        consumer text PRIMARY KEY,
+        last_seq bigint NOT NULL DEFAULT 0,
+        updated_at TIMESTAMP DEFAULT NOW()
+    )`,
+		`create table outbox_acks (
+        consumer text NOT NULL,
+        seq bigint NOT NULL,
+        PRIMARY KEY(consumer, seq)
+    )`}
+	ctx := context.Background()
+	db := GetPool()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Fatalf(">error beginning transaction:%v", err)
+	}
+	for _, sql := range sqls {
+		_, err = tx.Exec(ctx, sql)
+		if err != nil {
+			log.Fatalf(">error executing sql:%v", err)
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Fatalf("ERROR(CREATE):%v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package scramjet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+type PatchKind string
+
+const (
+	MergePatchKind PatchKind = "merge" // RFC 7396 (application/merge-patch+json)
+	JSONPatchKind  PatchKind = "json"  // RFC 6902 (application/json-patch+json)
+)
+
+// a partial update for one record e.g. {"name": "Robb"} as a merge patch
+type StagingPatch struct {
+	Id    Identifier
+	Kind  PatchKind
+	Patch json.RawMessage
+}
+
+// nothing in staging or resources to apply a patch to
+type NoBaseRecordError struct {
+	Id Identifier
+}
+
+func (e NoBaseRecordError) Error() string {
+	return fmt.Sprintf("no staging or resources record %s/%s to patch", e.Id.Type, e.Id.Id)
+}
+
+func PatchStaging(id Identifier, kind PatchKind, patch []byte) error {
+	return BulkPatchStaging(StagingPatch{Id: id, Kind: kind, Patch: patch})
+}
+
+// applies each patch to what is staged for the id (or, if nothing is, to
+// what is in resources) and stages the result - any patch that fails (or
+// has nothing to apply to) means nothing is staged
+// NOTE: patches for the same id are applied in the order sent
+func BulkPatchStaging(patches ...StagingPatch) error {
+	if len(patches) == 0 {
+		return nil
+	}
+	bases, err := retrievePatchBases(patches)
+	if err != nil {
+		return err
+	}
+
+	order := []Identifier{}
+	for _, patch := range patches {
+		base, ok := bases[patch.Id]
+		if !ok {
+			return NoBaseRecordError{Id: patch.Id}
+		}
+		merged, err := applyPatch(base, patch)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("patching %s/%s", patch.Id.Type, patch.Id.Id))
+		}
+		if !containsIdentifier(order, patch.Id) {
+			order = append(order, patch.Id)
+		}
+		bases[patch.Id] = merged
+	}
+
+	resources := make([]StagingResource, 0)
+	for _, id := range order {
+		resources = append(resources, StagingResource{Id: id.Id, Type: id.Type, Data: bases[id]})
+	}
+	return BulkAddStagingResources(resources...)
+}
+
+func applyPatch(base []byte, patch StagingPatch) ([]byte, error) {
+	switch patch.Kind {
+	case MergePatchKind:
+		return ApplyMergePatch(base, patch.Patch)
+	case JSONPatchKind:
+		var ops JSONPatch
+		err := json.Unmarshal(patch.Patch, &ops)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing json patch")
+		}
+		return ApplyJSONPatch(base, ops)
+	}
+	return nil, errors.New(fmt.Sprintf("unknown patch kind %q", patch.Kind))
+}
+
+func containsIdentifier(ids []Identifier, id Identifier) bool {
+	for _, existing := range ids {
+		if existing == id {
+			return true
+		}
+	}
+	return false
+}
+
+// staging data if there is any, otherwise resources data
+// NOTE: a staging row flagged for delete is an error, not a base
+func retrievePatchBases(patches []StagingPatch) (map[Identifier][]byte, error) {
+	db := GetPool()
+	ctx := context.Background()
+
+	idsByType := make(map[string][]string)
+	for _, patch := range patches {
+		idsByType[patch.Id.Type] = append(idsByType[patch.Id.Type], patch.Id.Id)
+	}
+
+	bases := make(map[Identifier][]byte)
+	sql := `SELECT i.id, s.data, coalesce(s.to_delete, false), r.data
+	  FROM unnest($1::text[]) AS i(id)
+	  LEFT JOIN staging s ON (s.id = i.id AND s.type = $2)
+	  LEFT JOIN resources r ON (r.id = i.id AND r.type = $2)`
+	for typeName, ids := range idsByType {
+		rows, err := db.Query(ctx, sql, ids, typeName)
+		if err != nil {
+			return nil, errors.Wrap(err, "retrieving records to patch")
+		}
+		for rows.Next() {
+			var id string
+			var staged, existing []byte
+			var toDelete bool
+			err = rows.Scan(&id, &staged, &toDelete, &existing)
+			if err != nil {
+				rows.Close()
+				return nil, errors.Wrap(err, "cannot scan in record to patch")
+			}
+			key := Identifier{Id: id, Type: typeName}
+			switch {
+			case toDelete:
+				rows.Close()
+				return nil, errors.New(fmt.Sprintf("%s/%s is flagged for delete - cannot patch", typeName, id))
+			case staged != nil:
+				bases[key] = staged
+			case existing != nil:
+				bases[key] = existing
+			}
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+	}
+	return bases, nil
+}
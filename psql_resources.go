@@ -7,7 +7,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"strings"
 	"time"
 
 	"github.com/jackc/pgx/pgtype"
@@ -132,86 +131,21 @@ func makeHash(text string) string {
 }
 
 // only does one at a time (not typically used)
-func SaveResource(obj Storeable) error {
-	ctx := context.Background()
+// NOTE: summary says whether it was inserted, updated or unchanged
+func SaveResource(obj Storeable) (TransferSummary, error) {
 	str, err := json.Marshal(obj.Object())
-
 	if err != nil {
-		log.Fatalln(err)
-		return err
+		return TransferSummary{}, err
 	}
-
-	db := GetPool()
-
-	hash := makeHash(string(str))
-
-	found := Resource{}
-	var data pgtype.JSON
-	var dataB pgtype.JSONB
-	err = data.Set(str)
-
-	if err != nil {
-		return err
-	}
-	err = dataB.Set(str)
-	if err != nil {
-		return err
-	}
-
-	res := &Resource{Id: obj.Identifier().Id,
-		Type:  obj.Identifier().Type,
-		Hash:  hash,
-		Data:  data,
-		DataB: dataB}
-
-	findSQL := `SELECT id, type, hash, data, data_b  
-	  FROM resources 
-	  WHERE (id = $1 AND type = $2)
-	`
-
-	row := db.QueryRow(ctx, findSQL, obj.Identifier().Id, obj.Identifier().Type)
-	notFoundError := row.Scan(&found.Id, &found.Type)
-
-	tx, err := db.Begin(ctx)
+	item := StagingResource{Id: obj.Identifier().Id,
+		Type: obj.Identifier().Type,
+		Data: str}
+	changes, err := moveStagingItemsToResources(item)
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
-	// either insert or update
-	if notFoundError != nil {
-		// TODO: created_at, updated_at
-		sql := `INSERT INTO resources (id, type, hash, data, data_b) 
-	      VALUES ($1, $2, $3, $4, $5)`
-		_, err := tx.Exec(ctx, sql, res.Id, res.Type, res.Hash, &res.Data, &res.DataB)
-
-		if err != nil {
-			return err
-		}
-	} else {
-
-		if strings.Compare(hash, found.Hash) == 0 {
-			// some kind of debug level?
-			log.Printf(">SKIPPING:%v\n", found.Id)
-		} else {
-			log.Printf(">UPDATE:%v\n", found.Id)
-			sql := `UPDATE resources 
-	        set id = $1, 
-		      type = $2, 
-		      hash = $3, 
-		      data = $4, 
-		      data_b = $5,
-		      updated_at = NOW()
-		      WHERE id = $1 and type = $2`
-			_, err := tx.Exec(ctx, sql, res.Id, res.Type, res.Hash, &res.Data, &res.DataB)
-
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	err = tx.Commit(ctx)
-	// TODO: return :insert or :update (or nil)
-	return err
+	deliverChanges(changes)
+	return summarizeTransfer([]StagingResource{item}, changes), nil
 }
 
 // TODO: the 'table_catalog' changes
@@ -334,27 +268,58 @@ func ClearResourceType(typeName string) error {
 	return nil
 }
 
-func moveStagingItemsToResources(items ...StagingResource) error {
-	var resources = make([]Resource, 0)
+// returns what was actually added or updated (unchanged are left out)
+func moveStagingItemsToResources(items ...StagingResource) ([]Change, error) {
+	ctx := context.Background()
+	db := GetPool()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+
+	// supposedly no-op if everything okay
+	defer tx.Rollback(ctx)
+
+	changes, _, err := upsertResources(ctx, tx, items)
+	if err != nil {
+		return changes, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return changes, errors.Wrap(err, "commit transaction")
+	}
+	return changes, nil
+}
 
+// the upsert (and outbox) part of moveStagingItemsToResources, inside
+// a transaction the caller commits - also returns the name of the
+// temporary table (id, type, hash...) of what was sent in, which
+// lasts until then
+func upsertResources(ctx context.Context, tx pgx.Tx, items []StagingResource) ([]Change, string, error) {
+	var resources = make([]Resource, 0)
+	var changes = make([]Change, 0)
 	var err error
-	ctx := context.Background()
 
 	for _, item := range items {
-		hash := makeHash(string(item.Data))
+		hash, err := hashResource(item.Type, item.Data)
+		if err != nil {
+			return changes, "", err
+		}
 
 		var data pgtype.JSON
 		var dataB pgtype.JSONB
 		err = data.Set(item.Data)
 
 		if err != nil {
-			return err
+			return changes, "", err
 		}
 
 		err = dataB.Set(item.Data)
 
 		if err != nil {
-			return err
+			return changes, "", err
 		}
 
 		res := &Resource{Id: item.Identifier().Id,
@@ -365,20 +330,12 @@ func moveStagingItemsToResources(items ...StagingResource) error {
 		resources = append(resources, *res)
 	}
 
-	db := GetPool()
-
-	tx, err := db.Begin(ctx)
-	if err != nil {
-		return errors.Wrap(err, "starting transaction")
-	}
-
-	// supposedly no-op if everything okay
-	defer tx.Rollback(ctx)
-
 	stamp := TimestampString()
+	// NOTE: staged_hash is always md5 of the data as it is in
+	// staging (hash depends on the type's HashOptions)
 	tmpSql := fmt.Sprintf(`CREATE TEMPORARY TABLE resource_data_%s
 	  (id text NOT NULL, type text NOT NULL, hash text NOT NULL,
-		data json NOT NULL, data_b jsonb NOT NULL,
+		staged_hash text NOT NULL, data json NOT NULL, data_b jsonb NOT NULL,
 		PRIMARY KEY(id, type)
 	  )
 	  ON COMMIT DROP
@@ -387,7 +344,7 @@ func moveStagingItemsToResources(items ...StagingResource) error {
 	_, err = tx.Exec(ctx, tmpSql)
 
 	if err != nil {
-		return errors.Wrap(err, "creating temporary table")
+		return changes, "", errors.Wrap(err, "creating temporary table")
 	}
 
 	// NOTE: don't commit yet (see ON COMMIT DROP)
@@ -396,109 +353,203 @@ func moveStagingItemsToResources(items ...StagingResource) error {
 		x := []byte{}
 		readError := res.Data.AssignTo(&x)
 		if readError != nil {
-			return errors.Wrap(err, fmt.Sprintf("could not read json data:%s", res.Identifier()))
+			return changes, "", errors.Wrap(err, fmt.Sprintf("could not read json data:%s", res.Identifier()))
 		}
 		y := []byte{}
 		readError = res.DataB.AssignTo(&y)
 
 		if readError != nil {
-			return errors.Wrap(err, fmt.Sprintf("could not read json data:%s", res.Identifier()))
+			return changes, "", errors.Wrap(err, fmt.Sprintf("could not read json data:%s", res.Identifier()))
 		}
 		inputRows = append(inputRows, []interface{}{res.Id,
 			res.Type,
 			res.Hash,
+			makeHash(string(x)),
 			x,
 			y})
 	}
 
 	_, err = tx.CopyFrom(ctx, pgx.Identifier{fmt.Sprintf("resource_data_%s", stamp)},
-		[]string{"id", "type", "hash", "data", "data_b"},
+		[]string{"id", "type", "hash", "staged_hash", "data", "data_b"},
 		pgx.CopyFromRows(inputRows))
 
 	if err != nil {
-		return errors.Wrap(err, "copying records into into temporary table")
+		return changes, "", errors.Wrap(err, "copying records into into temporary table")
 	}
 
-	sqlUpsert := fmt.Sprintf(`INSERT INTO resources (id, type, hash, data, data_b)
-	  SELECT id, type, hash, data, data_b 
-	  FROM resource_data_%s
-		
-	  ON CONFLICT (id, type) DO UPDATE SET 
-	    data = EXCLUDED.data, 
-		data_b = EXCLUDED.data_b, 
-		hash = EXCLUDED.hash,
-		updated_at = CASE 
-		  WHEN resources.hash != EXCLUDED.hash THEN NOW()
-		  ELSE resources.updated_at
-		END
-	`, stamp)
-
-	_, err = tx.Exec(ctx, sqlUpsert)
+	table := fmt.Sprintf("resource_data_%s", stamp)
+	changes, err = upsertFromTable(ctx, tx, table, true)
+	if err != nil {
+		return changes, "", err
+	}
+	err = writeOutbox(ctx, tx, changes)
 	if err != nil {
-		return errors.Wrap(err, "move from temporary to real table")
+		return changes, "", err
 	}
 
-	err = tx.Commit(ctx)
+	return changes, table, nil
+}
+
+// table has (id, type, hash, staged_hash, data, data_b) of what should be in resources,
+// with the hash already made - rows with the same hash as what is there
+// are left alone (and not returned as changes)
+// NOTE: withData false leaves Data/PreviousData (and Patch) empty on the changes
+func upsertFromTable(ctx context.Context, tx pgx.Tx, table string, withData bool) ([]Change, error) {
+	dataColumns := "t.data, p.data"
+	if !withData {
+		dataColumns = "NULL::json, NULL::json"
+	}
+	// NOTE: 'previous' sees the table as it was before the insert, so
+	// it tells adds (no previous) from updates
+	sqlUpsert := fmt.Sprintf(`WITH previous AS (
+	    SELECT r.id, r.type, r.hash, r.data
+	    FROM resources r
+	    JOIN %s t ON (r.id = t.id AND r.type = t.type)
+	  ), upserted AS (
+	    INSERT INTO resources (id, type, hash, data, data_b)
+	    SELECT id, type, hash, data, data_b 
+	    FROM %s
+	    ON CONFLICT (id, type) DO UPDATE SET 
+	      data = EXCLUDED.data, 
+	      data_b = EXCLUDED.data_b, 
+	      hash = EXCLUDED.hash,
+	      updated_at = NOW()
+	    WHERE resources.hash != EXCLUDED.hash
+	    RETURNING id, type, hash
+	  )
+	  SELECT u.id, u.type, u.hash, p.hash, %s
+	  FROM upserted u
+	  JOIN %s t ON (u.id = t.id AND u.type = t.type)
+	  LEFT JOIN previous p ON (u.id = p.id AND u.type = p.type)
+	`, table, table, dataColumns, table)
+
+	rows, err := tx.Query(ctx, sqlUpsert)
 	if err != nil {
-		return errors.Wrap(err, "commit transaction")
+		return nil, errors.Wrap(err, "move from temporary to real table")
 	}
-	return nil
+	changes, err := scanUpsertChanges(rows)
+	if err != nil {
+		return changes, errors.Wrap(err, "move from temporary to real table")
+	}
+	if patchesEnabled() {
+		addPatches(changes)
+	}
+	return changes, nil
+}
+
+// rows are (id, type, hash, previous hash, data, previous data)
+func scanUpsertChanges(rows pgx.Rows) ([]Change, error) {
+	defer rows.Close()
+	changes := make([]Change, 0)
+
+	for rows.Next() {
+		var change Change
+		var previousHash *string
+		var previousData []byte
+		err := rows.Scan(&change.Id.Id, &change.Id.Type, &change.Hash, &previousHash,
+			&change.Data, &previousData)
+		if err != nil {
+			return changes, errors.Wrap(err, "cannot scan in change")
+		}
+		if previousHash == nil {
+			change.Op = AddOp
+		} else {
+			change.Op = UpdateOp
+			change.PreviousHash = *previousHash
+			change.PreviousData = previousData
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
 }
 
 // NOTE: still need typname to clear from staging
 func BulkMoveStagingToResourcesByFilter(typeName string, filter Filter, items ...StagingResource) error {
-	err := moveStagingItemsToResources(items...)
+	_, err := moveStagingToResourcesByFilter(typeName, filter, items...)
+	return err
+}
+
+func moveStagingToResourcesByFilter(typeName string, filter Filter, items ...StagingResource) (TransferSummary, error) {
+	changes, err := moveStagingItemsToResources(items...)
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
+	// NOTE: resources is already committed - so the sinks get the changes
+	// even if clearing staging fails (a rerun would see them as unchanged)
+	deliverChanges(changes)
 	// now clear out staging ...
 	err = ClearStagingTypeValidByFilter(typeName, filter)
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
-	return nil
+	return summarizeTransfer(items, changes), nil
 }
 
 // NOTE: only need 'typeName' param for clearing out from staging
 func BulkMoveStagingTypeToResources(typeName string, items ...StagingResource) error {
-	err := moveStagingItemsToResources(items...)
+	_, err := moveStagingTypeToResources(typeName, items...)
+	return err
+}
+
+func moveStagingTypeToResources(typeName string, items ...StagingResource) (TransferSummary, error) {
+	changes, err := moveStagingItemsToResources(items...)
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
+	// NOTE: before clearing staging (see moveStagingToResourcesByFilter)
+	deliverChanges(changes)
 	err = ClearStagingTypeValid(typeName)
 	if err != nil {
-		return errors.Wrap(err, "clearing staging table")
+		return TransferSummary{}, errors.Wrap(err, "clearing staging table")
 	}
-	return nil
+	return summarizeTransfer(items, changes), nil
 }
 
 func BatchDeleteStagingFromResources(resources ...Identifiable) error {
+	_, err := deleteStagingFromResources(resources...)
+	return err
+}
+
+func deleteStagingFromResources(resources ...Identifiable) (TransferSummary, error) {
 	db := GetPool()
 	ctx := context.Background()
 	chunked := chunked(resources, 500)
+	changes := make([]Change, 0)
 	tx, err := db.Begin(ctx)
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
 	// noop if no problems
 	defer tx.Rollback(ctx)
 	for _, chunk := range chunked {
 		// how best to deal with chunked errors?
 		// cancel entire transaction?
-		err := batchDeleteStagingFromResources(ctx, chunk, tx)
+		deleted, err := batchDeleteStagingFromResources(ctx, chunk, tx)
 		if err != nil {
-			return errors.Wrap(err, "deleting staging from resources")
+			return TransferSummary{}, errors.Wrap(err, "deleting staging from resources")
 		}
+		changes = append(changes, deleted...)
+	}
+	// NOTE: cascaded deletes are in the summary (and sent to sinks) too
+	cascaded, err := applyRelationships(ctx, tx, changes)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	changes = append(changes, cascaded...)
+	err = writeOutbox(ctx, tx, changes)
+	if err != nil {
+		return TransferSummary{}, err
 	}
 	err = tx.Commit(ctx)
 	if err != nil {
-		return errors.Wrap(err, "committing transaction")
+		return TransferSummary{}, errors.Wrap(err, "committing transaction")
 	}
-	return nil
+	deliverChanges(changes)
+	return summarizeChanges(changes), nil
 }
 
 // how to enusure staging-resource IS identifiable
-func batchDeleteStagingFromResources(ctx context.Context, resources []Identifiable, tx pgx.Tx) error {
+func batchDeleteStagingFromResources(ctx context.Context, resources []Identifiable, tx pgx.Tx) ([]Change, error) {
 	// stole idea from here:
 	// https://stackoverflow.com/questions/71238345/how-to-do-where-in-any-on-multiple-columns-in-golang-with-pq-library
 	inSQL, args := "", []interface{}{}
@@ -509,20 +560,21 @@ func batchDeleteStagingFromResources(ctx context.Context, resources []Identifiab
 	}
 	inSQL = inSQL[:len(inSQL)-1] // drop last ","
 
-	sql := `DELETE from resources WHERE (id, type) IN (` + inSQL + `)`
-
-	_, err := tx.Exec(ctx, sql, args...)
+	sql := `DELETE from resources WHERE (id, type) IN (` + inSQL + `)
+	  RETURNING id, type, hash, data`
 
+	rows, err := tx.Query(ctx, sql, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return scanDeleteChanges(rows)
 }
 
 func BatchDeleteResourcesFromResources(resources ...Identifiable) error {
 	db := GetPool()
 	ctx := context.Background()
 	chunked := chunked(resources, 500)
+	changes := make([]Change, 0)
 	tx, err := db.Begin(ctx)
 	if err != nil {
 		return err
@@ -532,19 +584,30 @@ func BatchDeleteResourcesFromResources(resources ...Identifiable) error {
 	for _, chunk := range chunked {
 		// how best to deal with chunked errors?
 		// cancel entire transaction?
-		err := batchDeleteResourcesFromResources(ctx, chunk, tx)
+		deleted, err := batchDeleteResourcesFromResources(ctx, chunk, tx)
 		if err != nil {
 			return err
 		}
+		changes = append(changes, deleted...)
+	}
+	cascaded, err := applyRelationships(ctx, tx, changes)
+	if err != nil {
+		return err
+	}
+	changes = append(changes, cascaded...)
+	err = writeOutbox(ctx, tx, changes)
+	if err != nil {
+		return err
 	}
 	err = tx.Commit(ctx)
 	if err != nil {
 		return errors.Wrap(err, "committing transaction")
 	}
+	deliverChanges(changes)
 	return nil
 }
 
-func batchDeleteResourcesFromResources(ctx context.Context, resources []Identifiable, tx pgx.Tx) error {
+func batchDeleteResourcesFromResources(ctx context.Context, resources []Identifiable, tx pgx.Tx) ([]Change, error) {
 	// stole idea from here:
 	// https://stackoverflow.com/questions/71238345/how-to-do-where-in-any-on-multiple-columns-in-golang-with-pq-library
 	inSQL, args := "", []interface{}{}
@@ -555,34 +618,54 @@ func batchDeleteResourcesFromResources(ctx context.Context, resources []Identifi
 	}
 	inSQL = inSQL[:len(inSQL)-1] // drop last ","
 
-	sql := `DELETE from resources WHERE (id, type) IN (` + inSQL + `)`
-
-	_, err := tx.Exec(ctx, sql, args...)
+	sql := `DELETE from resources WHERE (id, type) IN (` + inSQL + `)
+	  RETURNING id, type, hash, data`
 
+	rows, err := tx.Query(ctx, sql, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return scanDeleteChanges(rows)
+}
+
+// rows are (id, type, hash, data) of what was removed
+func scanDeleteChanges(rows pgx.Rows) ([]Change, error) {
+	defer rows.Close()
+	changes := make([]Change, 0)
+	for rows.Next() {
+		change := Change{Op: DeleteOp}
+		err := rows.Scan(&change.Id.Id, &change.Id.Type, &change.PreviousHash, &change.PreviousData)
+		if err != nil {
+			return changes, errors.Wrap(err, "cannot scan in deleted resource")
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
 }
 
 func BulkRemoveStagingDeletedFromResources(typeName string) error {
+	_, err := removeStagingDeletedFromResources(typeName)
+	return err
+}
+
+func removeStagingDeletedFromResources(typeName string) (TransferSummary, error) {
 	deletes, err := RetrieveDeletedStaging(typeName)
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
-	err = BatchDeleteStagingFromResources(deletes...)
+	summary, err := deleteStagingFromResources(deletes...)
 	if err != nil {
-		return err
+		return summary, err
 	}
 	// TODO: then remove from staging?  or let caller ?
 	// in theory could use to remove from solr, rdf etc...
 	// but could also use notify
-	// no errors - would catch later with 'orphan' check
+	// no errors - would catch later with 'orphan' check (see FindOrphans)
 	err = ClearStagingTypeDeletes(typeName)
 	if err != nil {
-		return err
+		return summary, err
 	}
-	return nil
+	return summary, nil
 }
 
 func RemoveStagingDeletedFromResources(id string, typeName string) error {
@@ -597,7 +680,7 @@ func RemoveStagingDeletedFromResources(id string, typeName string) error {
 	// TODO: then remove from staging?  or let caller ?
 	// in theory could use to remove from solr, rdf etc...
 	// but could also use notify
-	// no errors - would catch later with 'orphan' check
+	// no errors - would catch later with 'orphan' check (see FindOrphans)
 	err = ClearDeletedFromStaging(id, typeName)
 	if err != nil {
 		return err
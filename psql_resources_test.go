@@ -572,7 +572,7 @@ func TestDiffOutExtras(t *testing.T) {
 		ExistingListMaker: current,
 	}
 	// mark them for delete
-	err = sj.ProcessDiff(finder)
+	_, err = sj.ProcessDiff(finder)
 
 	if err != nil {
 		t.Errorf("unable to mark records for delete:%s", err)
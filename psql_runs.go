@@ -0,0 +1,184 @@
+package scramjet
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// a recorded ScramjetIncremental run (see scramjet_runs)
+type ScramjetRun struct {
+	RunId      int
+	TypeName   string
+	Full       bool
+	StartedAt  time.Time
+	FinishedAt *time.Time
+	Succeeded  bool
+	Watermark  string
+	Summary    TransferSummary
+	Error      string
+}
+
+const runColumns = `run_id, type, full_sync, started_at, finished_at, succeeded,
+	  watermark, inserted, updated, unchanged, deleted, error`
+
+func scanRun(row pgx.Row) (ScramjetRun, error) {
+	var run ScramjetRun
+	var watermark, runError *string
+	err := row.Scan(&run.RunId, &run.TypeName, &run.Full, &run.StartedAt, &run.FinishedAt,
+		&run.Succeeded, &watermark, &run.Summary.Inserted, &run.Summary.Updated,
+		&run.Summary.Unchanged, &run.Summary.Deleted, &runError)
+	if watermark != nil {
+		run.Watermark = *watermark
+	}
+	if runError != nil {
+		run.Error = *runError
+	}
+	return run, err
+}
+
+// latest first
+func RetrieveRuns(typeName string) ([]ScramjetRun, error) {
+	db := GetPool()
+	ctx := context.Background()
+	runs := []ScramjetRun{}
+	sql := `SELECT ` + runColumns + `
+	  FROM scramjet_runs
+	  WHERE type = $1
+	  ORDER BY started_at DESC, run_id DESC`
+	rows, err := db.Query(ctx, sql, typeName)
+	if err != nil {
+		return runs, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return runs, errors.Wrap(err, "cannot scan in run")
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// the latest run of the type that worked (only full syncs if full is
+// true) - nil if there isn't one
+func LastSuccessfulRun(typeName string, full bool) (*ScramjetRun, error) {
+	db := GetPool()
+	ctx := context.Background()
+	sql := `SELECT ` + runColumns + `
+	  FROM scramjet_runs
+	  WHERE type = $1
+	  AND succeeded = TRUE
+	  AND ($2 = FALSE OR full_sync = TRUE)
+	  ORDER BY started_at DESC, run_id DESC
+	  LIMIT 1`
+	run, err := scanRun(db.QueryRow(ctx, sql, typeName, full))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving last run")
+	}
+	return &run, nil
+}
+
+func startRun(typeName string, full bool, startedAt time.Time) (int, error) {
+	db := GetPool()
+	ctx := context.Background()
+	sql := `INSERT INTO scramjet_runs (type, full_sync, started_at)
+	  VALUES ($1, $2, $3)
+	  RETURNING run_id`
+	var runId int
+	err := db.QueryRow(ctx, sql, typeName, full, startedAt).Scan(&runId)
+	if err != nil {
+		return 0, errors.Wrap(err, "recording run")
+	}
+	return runId, nil
+}
+
+func finishRun(runId int, watermark string, summary TransferSummary, runErr error) error {
+	db := GetPool()
+	ctx := context.Background()
+	var message *string
+	if runErr != nil {
+		text := runErr.Error()
+		message = &text
+	}
+	sql := `UPDATE scramjet_runs
+	  SET finished_at = NOW(), succeeded = $2, watermark = $3,
+	  inserted = $4, updated = $5, unchanged = $6, deleted = $7, error = $8
+	  WHERE run_id = $1`
+	_, err := db.Exec(ctx, sql, runId, runErr == nil, watermark, summary.Inserted,
+		summary.Updated, summary.Unchanged, summary.Deleted, message)
+	if err != nil {
+		return errors.Wrap(err, "recording end of run")
+	}
+	return nil
+}
+
+func ClearRuns(typeName string) error {
+	db := GetPool()
+	ctx := context.Background()
+	_, err := db.Exec(ctx, `DELETE FROM scramjet_runs WHERE type = $1`, typeName)
+	return err
+}
+
+func ScramjetRunsTableExists() bool {
+	var exists bool
+	ctx := context.Background()
+	db := GetPool()
+
+	catalog := GetDbName()
+	sqlExists := `SELECT EXISTS (
+        SELECT 1
+        FROM   information_schema.tables
+        WHERE  table_catalog = $1
+        AND    table_name = 'scramjet_runs'
+    )`
+	err := db.QueryRow(ctx, sqlExists, catalog).Scan(&exists)
+	if err != nil {
+		log.Fatalf("error checking if row exists %v", err)
+	}
+	return exists
+}
+
+/* NOTE: this calls Fatalf with errors */
+func MakeScramjetRunsSchema() {
+	sql := `create table scramjet_runs (
+        run_id serial PRIMARY KEY,
+        type text NOT NULL,
+        full_sync boolean NOT NULL DEFAULT FALSE,
+        started_at TIMESTAMP NOT NULL,
+        finished_at TIMESTAMP,
+        succeeded boolean NOT NULL DEFAULT FALSE,
+        watermark text,
+        inserted integer NOT NULL DEFAULT 0,
+        updated integer NOT NULL DEFAULT 0,
+        unchanged integer NOT NULL DEFAULT 0,
+        deleted integer NOT NULL DEFAULT 0,
+        error text
+    )`
+	ctx := context.Background()
+	db := GetPool()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Fatalf(">error beginning transaction:%v", err)
+	}
+	_, err = tx.Exec(ctx, sql)
+	if err != nil {
+		log.Fatalf(">error executing sql:%v", err)
+	}
+	_, err = tx.Exec(ctx, `CREATE INDEX scramjet_runs_type ON scramjet_runs (type, started_at)`)
+	if err != nil {
+		log.Fatalf(">error executing sql:%v", err)
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Fatalf("ERROR(CREATE):%v", err)
+	}
+}
@@ -264,10 +264,16 @@ func StashStaging(docs ...Storeable) error {
 
 // TODO: no test for this so far
 func ProcessTypeStagingFiltered(typeName string, filter Filter, validator ValidatorFunc) error {
+	validator = registeredValidator(typeName, validator)
 	valid, rejects, err := FilterTypeStagingByQuery(typeName, filter, validator)
 	if err != nil {
 		return err
 	}
+	valid, missing, err := processReferences(typeName, valid, rejects)
+	if err != nil {
+		return err
+	}
+	rejects = append(rejects, missing...)
 
 	err = BatchMarkValidInStaging(valid)
 	if err != nil {
@@ -281,10 +287,16 @@ func ProcessTypeStagingFiltered(typeName string, filter Filter, validator Valida
 }
 
 func ProcessTypeStaging(typeName string, validator ValidatorFunc) error {
+	validator = registeredValidator(typeName, validator)
 	valid, rejects, err := FilterTypeStaging(typeName, validator)
 	if err != nil {
 		return err
 	}
+	valid, missing, err := processReferences(typeName, valid, rejects)
+	if err != nil {
+		return err
+	}
+	rejects = append(rejects, missing...)
 
 	err = BatchMarkValidInStaging(valid)
 	if err != nil {
@@ -305,12 +317,19 @@ func ProcessSingleStaging(item Identifiable, validator ValidatorFunc) error {
 	if err != nil {
 		return err
 	}
-	valid := validator(string(res.Data))
+	valid := registeredValidator(id.Type, validator)(string(res.Data))
 
 	var results = make([]Identifiable, 0)
 	results = append(results, res)
 
 	if valid {
+		results, rejects, err := processReferences(id.Type, results, nil)
+		if err != nil {
+			return err
+		}
+		if len(rejects) > 0 {
+			return BatchMarkInvalidInStaging(rejects)
+		}
 		return BatchMarkValidInStaging(results)
 	} else {
 		return BatchMarkInvalidInStaging(results)
@@ -585,6 +604,7 @@ func MakeStagingSchema() {
         data json NOT NULL,
 		is_valid boolean DEFAULT FALSE,
 		to_delete boolean DEFAULT FALSE,
+        reject_reason text,
         PRIMARY KEY(id, type)
     )`
 
@@ -608,6 +628,18 @@ func MakeStagingSchema() {
 
 }
 
+// for a staging table made before reject_reason was added
+// NOTE: this calls Fatalf with errors
+func MigrateStagingSchema() {
+	sql := `ALTER TABLE staging ADD COLUMN IF NOT EXISTS reject_reason text`
+	db := GetPool()
+	ctx := context.Background()
+	_, err := db.Exec(ctx, sql)
+	if err != nil {
+		log.Fatalf(">error executing sql:%v", err)
+	}
+}
+
 func DropStaging() error {
 	db := GetPool()
 	ctx := context.Background()
@@ -975,10 +1007,14 @@ func uniqueObjects(idSlice []Storeable) []Storeable {
 	return list
 }
 
+// NOTE: anything without an id (see RawPacket) gets one from the type's
+// IdExtractor - what doesn't is not staged, and comes back as a
+// MissingIdError (after the rest are staged)
 func BulkAddStaging(items ...Storeable) error {
 	var resources = make([]StagingResource, 0)
 	var err error
 	ctx := context.Background()
+	items, missing := resolveIds(items)
 	// NOTE: not sure if these are necessary
 	list := uniqueObjects(items)
 
@@ -1043,6 +1079,9 @@ func BulkAddStaging(items ...Storeable) error {
 	if err != nil {
 		return errors.Wrap(err, "commit transaction")
 	}
+	if len(missing) > 0 {
+		return MissingIdError{Records: missing}
+	}
 	return nil
 }
 
@@ -17,7 +17,7 @@ func setup() {
 		Password:       "json_data",
 		Port:           5433,
 		User:           "json_data",
-		MaxConnections: 1,
+		MaxConnections: 4, // change listener holds one
 		AcquireTimeout: 30,
 		Application:    "test",
 	}
@@ -0,0 +1,143 @@
+package scramjet
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// a webhook payload that could not be delivered after all the retries
+type WebhookDeadLetter struct {
+	Id        int64
+	Sink      string
+	Url       string
+	Type      string
+	Operation ChangeOp
+	Payload   []byte
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func RecordWebhookDeadLetter(letter WebhookDeadLetter) error {
+	db := GetPool()
+	ctx := context.Background()
+	sql := `INSERT INTO webhook_dead_letters (sink, url, type, operation, payload,
+	    attempts, last_error)
+	  VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := db.Exec(ctx, sql, letter.Sink, letter.Url, letter.Type, string(letter.Operation),
+		letter.Payload, letter.Attempts, letter.LastError)
+	if err != nil {
+		return errors.Wrap(err, "recording webhook dead letter")
+	}
+	return nil
+}
+
+func RetrieveWebhookDeadLetters(sinkName string) ([]WebhookDeadLetter, error) {
+	db := GetPool()
+	ctx := context.Background()
+	letters := []WebhookDeadLetter{}
+
+	sql := `SELECT id, sink, url, type, operation, payload, attempts,
+	  coalesce(last_error, ''), created_at, updated_at
+	  FROM webhook_dead_letters
+	  WHERE sink = $1
+	  ORDER BY id`
+	rows, err := db.Query(ctx, sql, sinkName)
+	if err != nil {
+		return letters, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var letter WebhookDeadLetter
+		var op string
+		err = rows.Scan(&letter.Id, &letter.Sink, &letter.Url, &letter.Type, &op,
+			&letter.Payload, &letter.Attempts, &letter.LastError, &letter.CreatedAt, &letter.UpdatedAt)
+		if err != nil {
+			return letters, errors.Wrap(err, "could not read dead letter")
+		}
+		letter.Operation = ChangeOp(op)
+		letters = append(letters, letter)
+	}
+	return letters, rows.Err()
+}
+
+func UpdateWebhookDeadLetter(id int64, attempts int, cause error) error {
+	db := GetPool()
+	ctx := context.Background()
+	sql := `UPDATE webhook_dead_letters
+	  SET attempts = attempts + $2,
+	  last_error = $3,
+	  updated_at = NOW()
+	  WHERE id = $1`
+	_, err := db.Exec(ctx, sql, id, attempts, cause.Error())
+	return err
+}
+
+func DeleteWebhookDeadLetter(id int64) error {
+	db := GetPool()
+	ctx := context.Background()
+	_, err := db.Exec(ctx, `DELETE from webhook_dead_letters WHERE id = $1`, id)
+	return err
+}
+
+func ClearWebhookDeadLetters(sinkName string) error {
+	db := GetPool()
+	ctx := context.Background()
+	_, err := db.Exec(ctx, `DELETE from webhook_dead_letters WHERE sink = $1`, sinkName)
+	return err
+}
+
+func WebhookDeadLetterTableExists() bool {
+	var exists bool
+	ctx := context.Background()
+	db := GetPool()
+
+	catalog := GetDbName()
+	sqlExists := `SELECT EXISTS (
+        SELECT 1
+        FROM   information_schema.tables
+        WHERE  table_catalog = $1
+        AND    table_name = 'webhook_dead_letters'
+    )`
+	err := db.QueryRow(ctx, sqlExists, catalog).Scan(&exists)
+	if err != nil {
+		log.Fatalf("error checking if row exists %v", err)
+	}
+	return exists
+}
+
+/* NOTE: this calls Fatalf with errors */
+func MakeWebhookDeadLetterSchema() {
+	sql := `create table webhook_dead_letters (
+        id bigserial PRIMARY KEY,
+        sink text NOT NULL,
+        url text NOT NULL,
+        type text NOT NULL,
+        operation text NOT NULL,
+        payload json NOT NULL,
+        attempts integer DEFAULT 0,
+        last_error text,
+        created_at TIMESTAMP DEFAULT NOW(),
+        updated_at TIMESTAMP DEFAULT NOW()
+    )`
+	ctx := context.Background()
+	db := GetPool()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Fatalf(">error beginning transaction:%v", err)
+	}
+	_, err = tx.Exec(ctx, sql)
+	if err != nil {
+		log.Fatalf(">error executing sql:%v", err)
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Fatalf("ERROR(CREATE):%v", err)
+	}
+}
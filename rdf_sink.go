@@ -0,0 +1,207 @@
+package scramjet
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type RDFFormat string
+
+const (
+	NTriples RDFFormat = "ntriples"
+	Turtle   RDFFormat = "turtle"
+)
+
+// turns the json of one resource into rdf (in the sink's format)
+type RDFConverter func(id string, data []byte) (string, error)
+
+// applies changes to a SPARQL 1.1 Update endpoint - the triples of the
+// previous version are deleted and the new ones inserted, so anything
+// no longer in the data goes away
+// NOTE: DELETE DATA can't have blank nodes, so converters need to
+// mint iris for everything
+type RDFSink struct {
+	SinkName   string
+	UpdateUrl  string
+	Converters map[string]RDFConverter
+	Format     RDFFormat
+	Graph      string // optional named graph
+	BatchSize  int    // changes per update request
+	User       string
+	Password   string
+	Client     *http.Client
+}
+
+// only types with a converter are sent
+func MakeRDFSink(updateUrl string, format RDFFormat, converters map[string]RDFConverter) *RDFSink {
+	return &RDFSink{
+		SinkName:   fmt.Sprintf("rdf:%s", updateUrl),
+		UpdateUrl:  updateUrl,
+		Converters: converters,
+		Format:     format,
+		BatchSize:  100,
+		Client:     &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (s *RDFSink) Name() string {
+	return s.SinkName
+}
+
+func (s *RDFSink) Send(batch ChangeBatch) error {
+	converter, ok := s.Converters[batch.TypeName]
+	if !ok {
+		return nil
+	}
+	changes := make([]Change, 0)
+	changes = append(changes, batch.Adds...)
+	changes = append(changes, batch.Updates...)
+	changes = append(changes, batch.Deletes...)
+
+	size := s.BatchSize
+	if size <= 0 {
+		size = 100
+	}
+	for i := 0; i < len(changes); i += size {
+		end := i + size
+		if end > len(changes) {
+			end = len(changes)
+		}
+		update, err := s.BuildUpdate(converter, changes[i:end]...)
+		if err != nil {
+			return err
+		}
+		if len(update) == 0 {
+			continue
+		}
+		err = s.post(update)
+		if err != nil {
+			return errors.Wrap(err, "sending sparql update")
+		}
+	}
+	return nil
+}
+
+// one request with a DELETE DATA/INSERT DATA pair per change
+func (s *RDFSink) BuildUpdate(converter RDFConverter, changes ...Change) (string, error) {
+	prefixes := newPrefixSet()
+	operations := make([]string, 0)
+
+	for _, change := range changes {
+		if len(change.PreviousData) > 0 {
+			old, err := converter(change.Id.Id, change.PreviousData)
+			if err != nil {
+				return "", errors.Wrap(err, fmt.Sprintf("converting previous %s to rdf", change.Id))
+			}
+			body, err := s.dataBlock(prefixes, old)
+			if err != nil {
+				return "", errors.Wrap(err, fmt.Sprintf("converting previous %s to rdf", change.Id))
+			}
+			if len(strings.TrimSpace(body)) > 0 {
+				operations = append(operations, fmt.Sprintf("DELETE DATA { %s }", s.inGraph(body)))
+			}
+		}
+		if len(change.Data) > 0 {
+			triples, err := converter(change.Id.Id, change.Data)
+			if err != nil {
+				return "", errors.Wrap(err, fmt.Sprintf("converting %s to rdf", change.Id))
+			}
+			body, err := s.dataBlock(prefixes, triples)
+			if err != nil {
+				return "", errors.Wrap(err, fmt.Sprintf("converting %s to rdf", change.Id))
+			}
+			if len(strings.TrimSpace(body)) > 0 {
+				operations = append(operations, fmt.Sprintf("INSERT DATA { %s }", s.inGraph(body)))
+			}
+		}
+	}
+	if len(operations) == 0 {
+		return "", nil
+	}
+	return prefixes.prologue() + strings.Join(operations, " ;\n"), nil
+}
+
+// n-triples can go in as is
+func (s *RDFSink) dataBlock(prefixes *prefixSet, rdf string) (string, error) {
+	if s.Format == Turtle {
+		return prefixes.extract(rdf)
+	}
+	return strings.TrimSpace(rdf), nil
+}
+
+func (s *RDFSink) inGraph(triples string) string {
+	if len(s.Graph) == 0 {
+		return "\n" + triples + "\n"
+	}
+	return fmt.Sprintf("GRAPH <%s> {\n%s\n}", s.Graph, triples)
+}
+
+func (s *RDFSink) post(update string) error {
+	req, err := http.NewRequest("POST", s.UpdateUrl, bytes.NewBufferString(update))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/sparql-update")
+	if len(s.User) > 0 {
+		req.SetBasicAuth(s.User, s.Password)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("sparql endpoint returned %d: %s", resp.StatusCode, msg))
+	}
+	return nil
+}
+
+// turtle prefix declarations aren't allowed inside DATA blocks, so
+// they are pulled out and turned into a sparql prologue
+var turtlePrefix = regexp.MustCompile(`(?im)^\s*(@prefix|prefix)\s+([A-Za-z0-9_.-]*):\s*<([^>]*)>\s*\.?\s*$`)
+
+type prefixSet struct {
+	order []string
+	iris  map[string]string
+}
+
+func newPrefixSet() *prefixSet {
+	return &prefixSet{order: []string{}, iris: make(map[string]string)}
+}
+
+func (p *prefixSet) extract(rdf string) (string, error) {
+	var conflict error
+	body := turtlePrefix.ReplaceAllStringFunc(rdf, func(line string) string {
+		match := turtlePrefix.FindStringSubmatch(line)
+		name, iri := match[2], match[3]
+		existing, ok := p.iris[name]
+		if !ok {
+			p.order = append(p.order, name)
+			p.iris[name] = iri
+		} else if existing != iri {
+			conflict = errors.New(fmt.Sprintf("prefix %s: used for both <%s> and <%s>", name, existing, iri))
+		}
+		return ""
+	})
+	return strings.TrimSpace(body), conflict
+}
+
+func (p *prefixSet) prologue() string {
+	prologue := ""
+	for _, name := range p.order {
+		prologue += fmt.Sprintf("PREFIX %s: <%s>\n", name, p.iris[name])
+	}
+	return prologue
+}
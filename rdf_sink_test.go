@@ -0,0 +1,77 @@
+package scramjet_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+func personToTurtle(id string, data []byte) (string, error) {
+	var person TestPerson
+	err := json.Unmarshal(data, &person)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`@prefix rdfs: <http://www.w3.org/2000/01/rdf-schema#> .
+<http://vivo.example.edu/individual/%s> rdfs:label "%s" .`, id, person.Name), nil
+}
+
+func TestRDFSinkUpdate(t *testing.T) {
+	updates := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/sparql-update" {
+			t.Errorf("wrong content type %s\n", r.Header.Get("Content-Type"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		updates = append(updates, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := sj.MakeRDFSink(server.URL, sj.Turtle,
+		map[string]sj.RDFConverter{"person": personToTurtle})
+	sink.Graph = "http://vitro.mannlib.cornell.edu/default/vitro-kb-2"
+
+	batch := sj.MakeChangeBatch("person",
+		sj.Change{Id: sj.Identifier{Id: "per0000001", Type: "person"}, Op: sj.UpdateOp,
+			Data:         []byte(`{"id": "per0000001", "name": "Robb"}`),
+			PreviousData: []byte(`{"id": "per0000001", "name": "Rob"}`)},
+		sj.Change{Id: sj.Identifier{Id: "per0000002", Type: "person"}, Op: sj.DeleteOp,
+			PreviousData: []byte(`{"id": "per0000002", "name": "Gone"}`)},
+	)
+	err := sink.Send(batch)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(updates) != 1 {
+		t.Fatalf("should be 1 update request - not %d\n", len(updates))
+	}
+	update := updates[0]
+	if !strings.HasPrefix(update, "PREFIX rdfs: <http://www.w3.org/2000/01/rdf-schema#>") {
+		t.Errorf("turtle prefix should become sparql prologue:\n%s\n", update)
+	}
+	if strings.Contains(update, "@prefix") {
+		t.Errorf("turtle prefix left in data block:\n%s\n", update)
+	}
+	// stale triple removed, new one added, deleted one removed
+	for _, expected := range []string{
+		`DELETE DATA { GRAPH <http://vitro.mannlib.cornell.edu/default/vitro-kb-2> {
+<http://vivo.example.edu/individual/per0000001> rdfs:label "Rob" .`,
+		`INSERT DATA { GRAPH <http://vitro.mannlib.cornell.edu/default/vitro-kb-2> {
+<http://vivo.example.edu/individual/per0000001> rdfs:label "Robb" .`,
+		`<http://vivo.example.edu/individual/per0000002> rdfs:label "Gone" .`,
+	} {
+		if !strings.Contains(update, expected) {
+			t.Errorf("update missing %s:\n%s\n", expected, update)
+		}
+	}
+	if strings.Count(update, "INSERT DATA") != 1 || strings.Count(update, "DELETE DATA") != 2 {
+		t.Errorf("expected 2 deletes and 1 insert:\n%s\n", update)
+	}
+}
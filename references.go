@@ -0,0 +1,253 @@
+package scramjet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// a staged record that failed a reference check (see Relationship.Enforce)
+type StagingRejection struct {
+	Id     Identifier
+	Reason string
+}
+
+func (r StagingRejection) Identifier() Identifier {
+	return r.Id
+}
+
+// relationships checked when typeName is validated
+func enforcedReferences(typeName string) []Relationship {
+	enforced := []Relationship{}
+	for _, relationship := range GetRelationships() {
+		if relationship.Enforce && relationship.ChildType == typeName {
+			enforced = append(enforced, relationship)
+		}
+	}
+	return enforced
+}
+
+// valid (by the validator) items of typeName that also point at parents
+// that exist - in resources, valid in staging, or (for a type that points
+// at itself) in valid - the rest come back as rejects with a reason,
+// which is also saved in staging.reject_reason
+// NOTE: a missing (or null) field is not checked
+func processReferences(typeName string, valid []Identifiable, rejected []Identifiable) ([]Identifiable, []Identifiable, error) {
+	relationships := enforcedReferences(typeName)
+	if len(relationships) == 0 {
+		return valid, []Identifiable{}, nil
+	}
+	reasons := make(map[Identifier][]string)
+	for _, relationship := range relationships {
+		missing, err := checkReferences(context.Background(), GetPool(), relationship, valid, "")
+		if err != nil {
+			return valid, nil, err
+		}
+		for id, reason := range missing {
+			reasons[id] = append(reasons[id], reason)
+		}
+	}
+
+	passed := make([]Identifiable, 0)
+	rejects := make([]Identifiable, 0)
+	rejections := make([]StagingRejection, 0)
+	// NOTE: failed the validator - so any reason from before is out of date
+	for _, item := range rejected {
+		rejections = append(rejections, StagingRejection{Id: item.Identifier()})
+	}
+	for _, item := range valid {
+		id := item.Identifier()
+		if found, ok := reasons[id]; ok {
+			rejection := StagingRejection{Id: id, Reason: strings.Join(found, "; ")}
+			rejects = append(rejects, rejection)
+			rejections = append(rejections, rejection)
+		} else {
+			passed = append(passed, item)
+			// NOTE: clears any reason from before
+			rejections = append(rejections, StagingRejection{Id: id})
+		}
+	}
+	err := recordRejectReasons(rejections)
+	if err != nil {
+		return valid, nil, err
+	}
+	return passed, rejects, nil
+}
+
+// the pool or a transaction
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// items (with the reason) that point at a parent that isn't there
+// NOTE: scratch is a table standing in for staging (see dryRunTransfer),
+// "" if there isn't one
+func checkReferences(ctx context.Context, db querier, relationship Relationship, items []Identifiable, scratch string) (map[Identifier]string, error) {
+	references := make(map[Identifier][]string)
+	unreadable := make(map[Identifier]string)
+	wanted := []string{}
+	batch := make(map[string]bool)
+	for _, item := range items {
+		staged, ok := item.(StagingResource)
+		if !ok {
+			continue
+		}
+		batch[staged.Id] = true
+		parentIds, err := referencedIds(staged.Data, relationship)
+		if err != nil {
+			unreadable[staged.Identifier()] = fmt.Sprintf("%s: %s", relationship.Field, err)
+			continue
+		}
+		references[staged.Identifier()] = parentIds
+		wanted = append(wanted, parentIds...)
+	}
+	if len(wanted) == 0 {
+		return unreadable, nil
+	}
+
+	found, err := existingParents(ctx, db, relationship.ParentType, wanted, scratch)
+	if err != nil {
+		return nil, err
+	}
+	if relationship.ParentType == relationship.ChildType {
+		for id := range batch {
+			found[id] = true
+		}
+	}
+
+	missing := unreadable
+	for id, parentIds := range references {
+		notFound := []string{}
+		for _, parentId := range parentIds {
+			if !found[parentId] {
+				notFound = append(notFound, parentId)
+			}
+		}
+		if len(notFound) > 0 {
+			missing[id] = fmt.Sprintf("%s: %s %s not found", relationship.Field,
+				relationship.ParentType, strings.Join(notFound, ", "))
+		}
+	}
+	return missing, nil
+}
+
+// the parent id(s) in the field - strings or numbers
+func referencedIds(data []byte, relationship Relationship) ([]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	err := decoder.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := lookupPath(doc, relationship.Field)
+	if !ok || value == nil {
+		return []string{}, nil
+	}
+	values := []interface{}{value}
+	if list, isList := value.([]interface{}); isList {
+		values = list
+	}
+	ids := []string{}
+	for _, item := range values {
+		switch id := item.(type) {
+		case string:
+			ids = append(ids, id)
+		case json.Number:
+			ids = append(ids, id.String())
+		default:
+			return nil, errors.New(fmt.Sprintf("%v is not an id", item))
+		}
+	}
+	return ids, nil
+}
+
+// which of the ids are in resources, or valid in staging (the same batch)
+// - or in scratch, if there is one
+func existingParents(ctx context.Context, db querier, parentType string, ids []string, scratch string) (map[string]bool, error) {
+	sql := `SELECT id FROM resources WHERE type = $1 AND id = ANY($2)
+	  UNION
+	  SELECT id FROM staging WHERE type = $1 AND id = ANY($2)
+	  AND is_valid = TRUE AND to_delete IS NOT TRUE`
+	if scratch != "" {
+		sql += fmt.Sprintf(`
+	  UNION
+	  SELECT id FROM %s WHERE type = $1 AND id = ANY($2)`, scratch)
+	}
+	rows, err := db.Query(ctx, sql, parentType, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("looking up %s references", parentType))
+	}
+	defer rows.Close()
+	found := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot scan in reference")
+		}
+		found[id] = true
+	}
+	return found, rows.Err()
+}
+
+// an empty reason clears it
+func recordRejectReasons(rejections []StagingRejection) error {
+	if len(rejections) == 0 {
+		return nil
+	}
+	db := GetPool()
+	ctx := context.Background()
+	ids, types, reasons := []string{}, []string{}, []*string{}
+	for _, rejection := range rejections {
+		ids = append(ids, rejection.Id.Id)
+		types = append(types, rejection.Id.Type)
+		if rejection.Reason == "" {
+			reasons = append(reasons, nil)
+		} else {
+			reason := rejection.Reason
+			reasons = append(reasons, &reason)
+		}
+	}
+	sql := `UPDATE staging s
+	  SET reject_reason = r.reason
+	  FROM unnest($1::text[], $2::text[], $3::text[]) AS r(id, type, reason)
+	  WHERE s.id = r.id AND s.type = r.type`
+	_, err := db.Exec(ctx, sql, ids, types, reasons)
+	if err != nil {
+		return errors.Wrap(err, "recording reject reasons")
+	}
+	return nil
+}
+
+// staged records of the type that failed a reference check, and why
+func RetrieveRejectedStaging(typeName string) ([]StagingRejection, error) {
+	db := GetPool()
+	ctx := context.Background()
+	rejections := []StagingRejection{}
+	sql := `SELECT id, type, reject_reason
+	  FROM staging
+	  WHERE type = $1
+	  AND is_valid = FALSE
+	  AND reject_reason IS NOT NULL
+	  ORDER BY id`
+	rows, err := db.Query(ctx, sql, typeName)
+	if err != nil {
+		return rejections, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rejection StagingRejection
+		err = rows.Scan(&rejection.Id.Id, &rejection.Id.Type, &rejection.Reason)
+		if err != nil {
+			return rejections, errors.Wrap(err, "cannot scan in rejection")
+		}
+		rejections = append(rejections, rejection)
+	}
+	return rejections, rows.Err()
+}
@@ -0,0 +1,235 @@
+package scramjet
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// what the child's field holds
+type Cardinality int
+
+const (
+	ManyToOne  Cardinality = iota // one parent id e.g. education.personId
+	OneToOne                      // same as ManyToOne as far as deletes go
+	ManyToMany                    // an array of parent ids e.g. publication.authorIds
+)
+
+// what happens to a child when its parent is deleted
+type DeleteAction int
+
+const (
+	// left alone, but recorded in resource_orphans (default)
+	FlagOrphan DeleteAction = iota
+	// deleted from resources in the same transaction (and
+	// so on down to its own children)
+	CascadeDelete
+	// the whole delete fails with a DeleteBlockedError
+	BlockDelete
+)
+
+// e.g. education.personId -> person
+// NOTE: with ManyToMany the child only counts as dependent once
+// every parent in the list is gone
+type Relationship struct {
+	ParentType  string
+	ChildType   string
+	Field       string // json path in the child e.g. "personId" or "$.person.id"
+	Cardinality Cardinality
+	OnDelete    DeleteAction
+	// children are only valid (at transfer) if the parent exists
+	Enforce bool
+}
+
+type DeleteBlockedError struct {
+	ParentType string
+	ChildType  string
+	ChildIds   []string
+}
+
+func (e DeleteBlockedError) Error() string {
+	return fmt.Sprintf("cannot delete %s - %d %s still depend on it (%s)", e.ParentType,
+		len(e.ChildIds), e.ChildType, strings.Join(e.ChildIds, ", "))
+}
+
+var relationshipMutex sync.RWMutex
+var relationships = make([]Relationship, 0)
+
+func RegisterRelationship(relationship Relationship) {
+	relationshipMutex.Lock()
+	defer relationshipMutex.Unlock()
+	relationships = append(relationships, relationship)
+}
+
+func ClearRelationships() {
+	relationshipMutex.Lock()
+	defer relationshipMutex.Unlock()
+	relationships = make([]Relationship, 0)
+}
+
+// all of them if no parent type given
+func GetRelationships(parentType ...string) []Relationship {
+	relationshipMutex.RLock()
+	defer relationshipMutex.RUnlock()
+	matches := make([]Relationship, 0)
+	for _, relationship := range relationships {
+		if len(parentType) == 0 || relationship.ParentType == parentType[0] {
+			matches = append(matches, relationship)
+		}
+	}
+	return matches
+}
+
+// the types plus anything a delete could cascade to or flag
+// (so they can all be locked together)
+func withDependentTypes(typeNames ...string) []string {
+	seen := make(map[string]bool)
+	all := []string{}
+	queue := append([]string{}, typeNames...)
+	for len(queue) > 0 {
+		typeName := queue[0]
+		queue = queue[1:]
+		if seen[typeName] {
+			continue
+		}
+		seen[typeName] = true
+		all = append(all, typeName)
+		for _, relationship := range GetRelationships(typeName) {
+			queue = append(queue, relationship.ChildType)
+		}
+	}
+	return all
+}
+
+// called in the same transaction as a delete from resources - returns
+// any cascaded deletes (to go along with the rest of the changes)
+func applyRelationships(ctx context.Context, tx pgx.Tx, deleted []Change) ([]Change, error) {
+	cascaded := make([]Change, 0)
+	pending := deleted
+	for len(pending) > 0 {
+		idsByType := make(map[string][]string)
+		for _, change := range pending {
+			idsByType[change.Id.Type] = append(idsByType[change.Id.Type], change.Id.Id)
+		}
+		err := clearOrphans(ctx, tx, pending)
+		if err != nil {
+			return cascaded, err
+		}
+		pending = make([]Change, 0)
+
+		// NOTE: sorted so it happens in the same order every time
+		typeNames := []string{}
+		for typeName := range idsByType {
+			typeNames = append(typeNames, typeName)
+		}
+		sort.Strings(typeNames)
+		for _, typeName := range typeNames {
+			for _, relationship := range GetRelationships(typeName) {
+				changes, err := applyRelationship(ctx, tx, relationship, idsByType[typeName])
+				if err != nil {
+					return cascaded, err
+				}
+				pending = append(pending, changes...)
+			}
+		}
+		cascaded = append(cascaded, pending...)
+	}
+	return cascaded, nil
+}
+
+func applyRelationship(ctx context.Context, tx pgx.Tx, relationship Relationship, deletedIds []string) ([]Change, error) {
+	dependents, err := findDependents(ctx, tx, relationship, deletedIds)
+	if err != nil {
+		return nil, err
+	}
+	if len(dependents) == 0 {
+		return nil, nil
+	}
+	childIds, parentIds := []string{}, []string{}
+	for _, dependent := range dependents {
+		childIds = append(childIds, dependent.ChildId)
+		parentIds = append(parentIds, dependent.ParentId)
+	}
+	switch relationship.OnDelete {
+	case BlockDelete:
+		return nil, DeleteBlockedError{ParentType: relationship.ParentType,
+			ChildType: relationship.ChildType, ChildIds: childIds}
+	case CascadeDelete:
+		sql := `DELETE FROM resources WHERE type = $1 AND id = ANY($2)
+		  RETURNING id, type, hash, data`
+		rows, err := tx.Query(ctx, sql, relationship.ChildType, childIds)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("cascading delete to %s", relationship.ChildType))
+		}
+		return scanDeleteChanges(rows)
+	}
+	sql := `INSERT INTO resource_orphans (id, type, parent_type, parent_id, field)
+	  SELECT t.id, $3, $4, t.parent_id, $5
+	  FROM unnest($1::text[], $2::text[]) AS t(id, parent_id)
+	  ON CONFLICT DO NOTHING`
+	_, err = tx.Exec(ctx, sql, childIds, parentIds, relationship.ChildType,
+		relationship.ParentType, relationship.Field)
+	if err != nil {
+		return nil, errors.Wrap(err, "flagging orphans")
+	}
+	return nil, nil
+}
+
+type dependent struct {
+	ChildId  string
+	ParentId string
+}
+
+// children pointing at any of the parent ids (which are already deleted)
+func findDependents(ctx context.Context, tx pgx.Tx, relationship Relationship, parentIds []string) ([]dependent, error) {
+	path := splitPath(relationship.Field)
+	args := []interface{}{relationship.ChildType, path, parentIds}
+	var sql string
+	if relationship.Cardinality == ManyToMany {
+		// NOTE: only when none of the other parents are still there
+		sql = `SELECT c.id, p.id
+		  FROM resources c
+		  CROSS JOIN LATERAL jsonb_array_elements_text(
+		    CASE WHEN jsonb_typeof(c.data_b #> $2) = 'array'
+		    THEN c.data_b #> $2 ELSE '[]'::jsonb END) AS p(id)
+		  WHERE c.type = $1
+		  AND p.id = ANY($3)
+		  AND NOT EXISTS (
+		    SELECT 1 FROM jsonb_array_elements_text(c.data_b #> $2) AS o(id)
+		    JOIN resources r ON (r.id = o.id AND r.type = $4)
+		  )`
+		args = append(args, relationship.ParentType)
+	} else {
+		sql = `SELECT c.id, c.data_b #>> $2
+		  FROM resources c
+		  WHERE c.type = $1
+		  AND c.data_b #>> $2 = ANY($3)`
+	}
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("finding %s depending on %s", relationship.ChildType,
+			relationship.ParentType))
+	}
+	defer rows.Close()
+	dependents := []dependent{}
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var found dependent
+		err = rows.Scan(&found.ChildId, &found.ParentId)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot scan in dependent")
+		}
+		// NOTE: one per child (the first parent)
+		if seen[found.ChildId] {
+			continue
+		}
+		seen[found.ChildId] = true
+		dependents = append(dependents, found)
+	}
+	return dependents, rows.Err()
+}
@@ -0,0 +1,178 @@
+package scramjet_test
+
+import (
+	"strings"
+	"testing"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+	"github.com/pkg/errors"
+)
+
+type TestEducation struct {
+	Id       string `json:"id"`
+	PersonId string `json:"personId"`
+	Degree   string `json:"degree"`
+}
+
+type TestAuthoredPublication struct {
+	Id        string   `json:"id"`
+	AuthorIds []string `json:"authorIds"`
+	Title     string   `json:"title"`
+}
+
+func stashRelated(t *testing.T) {
+	alwaysOkay := func(json string) bool { return true }
+	person1 := TestPerson{Id: "per0000001", Name: "Test1"}
+	person2 := TestPerson{Id: "per0000002", Name: "Test2"}
+	education := TestEducation{Id: "edu0000001", PersonId: person1.Id, Degree: "BA"}
+	pub1 := TestAuthoredPublication{Id: "pub0000001", AuthorIds: []string{person1.Id}, Title: "Alone"}
+	pub2 := TestAuthoredPublication{Id: "pub0000002", AuthorIds: []string{person1.Id, person2.Id}, Title: "Together"}
+
+	err := sj.StashStaging(sj.MakePacket(person1.Id, "person", person1),
+		sj.MakePacket(person2.Id, "person", person2),
+		sj.MakePacket(education.Id, "education", education),
+		sj.MakePacket(pub1.Id, "publication", pub1),
+		sj.MakePacket(pub2.Id, "publication", pub2))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	for _, typeName := range []string{"person", "education", "publication"} {
+		_, err = sj.TransferAll(typeName, alwaysOkay)
+		if err != nil {
+			t.Errorf("err=%v\n", err)
+		}
+	}
+}
+
+func TestCascadeDelete(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	sj.ClearAllOrphans()
+	defer sj.ClearRelationships()
+
+	sj.RegisterRelationship(sj.Relationship{ParentType: "person", ChildType: "education",
+		Field: "personId", OnDelete: sj.CascadeDelete})
+	sj.RegisterRelationship(sj.Relationship{ParentType: "person", ChildType: "publication",
+		Field: "authorIds", Cardinality: sj.ManyToMany, OnDelete: sj.FlagOrphan})
+	stashRelated(t)
+
+	summary, err := sj.RemoveRecords(sj.MakeStub("per0000001", "person"))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	// the person and their education
+	if summary.Deleted != 2 {
+		t.Errorf("should have deleted 2 - not %s\n", summary)
+	}
+	if sj.ResourceCount("education") != 0 {
+		t.Errorf("education should have been deleted along with person\n")
+	}
+
+	// only pub0000001 - pub0000002 still has per0000002
+	orphans, err := sj.RetrieveOrphans("publication")
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(orphans) != 1 || orphans[0].Id.Id != "pub0000001" {
+		t.Errorf("pub0000001 should be the only orphan - not %v\n", orphans)
+	}
+	if sj.ResourceCount("publication") != 2 {
+		t.Errorf("orphans should not be deleted\n")
+	}
+}
+
+func TestBlockedDelete(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	sj.ClearAllOrphans()
+	defer sj.ClearRelationships()
+
+	sj.RegisterRelationship(sj.Relationship{ParentType: "person", ChildType: "education",
+		Field: "personId", OnDelete: sj.BlockDelete})
+	stashRelated(t)
+
+	_, err := sj.RemoveRecords(sj.MakeStub("per0000001", "person"))
+	if _, ok := errors.Cause(err).(sj.DeleteBlockedError); !ok {
+		t.Errorf("should be a DeleteBlockedError - not %v\n", err)
+	}
+	if sj.ResourceCount("person") != 2 {
+		t.Errorf("person should not have been deleted\n")
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	stashRelated(t)
+
+	// NOTE: nothing registered, so nothing cascades or is flagged
+	err := sj.BulkRemoveResources(sj.MakeStub("per0000001", "person"))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	orphans, err := sj.FindOrphans(
+		sj.Relationship{ParentType: "person", ChildType: "education", Field: "personId"},
+		sj.Relationship{ParentType: "person", ChildType: "publication", Field: "authorIds",
+			Cardinality: sj.ManyToMany})
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(orphans) != 2 || orphans[0].Id.Id != "edu0000001" || orphans[1].Id.Id != "pub0000001" {
+		t.Errorf("should be edu0000001 and pub0000001 - not %v\n", orphans)
+	}
+
+	err = sj.FlagOrphansForDelete(orphans...)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	deletes, err := sj.RetrieveDeletedStaging("education")
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(deletes) != 1 {
+		t.Errorf("education orphan should be flagged for delete - not %v\n", deletes)
+	}
+}
+
+func TestEnforcedReferences(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	defer sj.ClearRelationships()
+	alwaysOkay := func(json string) bool { return true }
+
+	sj.RegisterRelationship(sj.Relationship{ParentType: "person", ChildType: "education",
+		Field: "personId", Enforce: true})
+
+	person := TestPerson{Id: "per0000001", Name: "Test1"}
+	education1 := TestEducation{Id: "edu0000001", PersonId: person.Id, Degree: "BA"}
+	education2 := TestEducation{Id: "edu0000002", PersonId: "per0000009", Degree: "MA"}
+	err := sj.StashStaging(sj.MakePacket(person.Id, "person", person),
+		sj.MakePacket(education1.Id, "education", education1),
+		sj.MakePacket(education2.Id, "education", education2))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	// NOTE: person is only valid in staging (not transferred yet) - still counts
+	err = sj.ProcessTypeStaging("person", alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	summary, err := sj.TransferAll("education", alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Inserted != 1 {
+		t.Errorf("only edu0000001 should be transferred - not %s\n", summary)
+	}
+
+	rejections, err := sj.RetrieveRejectedStaging("education")
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(rejections) != 1 || rejections[0].Id.Id != "edu0000002" ||
+		!strings.Contains(rejections[0].Reason, "per0000009") {
+		t.Errorf("edu0000002 should be rejected for per0000009 - not %v\n", rejections)
+	}
+}
@@ -0,0 +1,167 @@
+package scramjet
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// all the changes of one type from one transfer (or delete)
+type ChangeBatch struct {
+	TypeName string
+	Adds     []Change
+	Updates  []Change
+	Deletes  []Change
+}
+
+func (b ChangeBatch) Len() int {
+	return len(b.Adds) + len(b.Updates) + len(b.Deletes)
+}
+
+func MakeChangeBatch(typeName string, changes ...Change) ChangeBatch {
+	batch := ChangeBatch{TypeName: typeName}
+	for _, change := range changes {
+		switch change.Op {
+		case AddOp:
+			batch.Adds = append(batch.Adds, change)
+		case UpdateOp:
+			batch.Updates = append(batch.Updates, change)
+		case DeleteOp:
+			batch.Deletes = append(batch.Deletes, change)
+		}
+	}
+	return batch
+}
+
+// somewhere downstream to push changes (solr, rdf etc...)
+// NOTE: Name() is used to track failed deliveries, so it should
+// be unique and stay the same between runs
+type Sink interface {
+	Name() string
+	Send(batch ChangeBatch) error
+}
+
+var sinkMutex sync.RWMutex
+var sinks = make(map[string][]Sink)
+
+// key used for sinks that get every type
+const allTypes = "*"
+
+// no typeNames means every type
+func RegisterSink(sink Sink, typeNames ...string) {
+	sinkMutex.Lock()
+	defer sinkMutex.Unlock()
+	if len(typeNames) == 0 {
+		typeNames = []string{allTypes}
+	}
+	for _, typeName := range typeNames {
+		sinks[typeName] = append(sinks[typeName], sink)
+	}
+}
+
+func ClearSinks() {
+	sinkMutex.Lock()
+	defer sinkMutex.Unlock()
+	sinks = make(map[string][]Sink)
+}
+
+func SinksFor(typeName string) []Sink {
+	sinkMutex.RLock()
+	defer sinkMutex.RUnlock()
+	list := []Sink{}
+	list = append(list, sinks[typeName]...)
+	if typeName != allTypes {
+		list = append(list, sinks[allTypes]...)
+	}
+	return list
+}
+
+func sinkByName(name string) (Sink, bool) {
+	sinkMutex.RLock()
+	defer sinkMutex.RUnlock()
+	for _, list := range sinks {
+		for _, sink := range list {
+			if sink.Name() == name {
+				return sink, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func hasSinks() bool {
+	sinkMutex.RLock()
+	defer sinkMutex.RUnlock()
+	return len(sinks) > 0
+}
+
+// NOTE: called after resources are committed - so a failing sink
+// does not undo the transfer, it's recorded (see RetryDeliveries)
+func deliverChanges(changes []Change) {
+	if len(changes) == 0 || !hasSinks() {
+		return
+	}
+	logger := GetLogger()
+	typeNames, grouped := groupChangesByType(changes)
+	for _, typeName := range typeNames {
+		batch := MakeChangeBatch(typeName, grouped[typeName]...)
+		for _, sink := range SinksFor(typeName) {
+			err := sink.Send(batch)
+			if err == nil {
+				// NOTE: anything older that failed would be sent again by
+				// RetryDeliveries - over the top of this
+				clearErr := ClearDeliveries(sink.Name(), grouped[typeName]...)
+				if clearErr != nil {
+					logger.Info(fmt.Sprintf("could not clear old deliveries for %s: %s\n",
+						sink.Name(), clearErr))
+				}
+				continue
+			}
+			logger.Info(fmt.Sprintf("sink %s failed for %s: %s\n", sink.Name(), typeName, err))
+			recordErr := RecordFailedDeliveries(sink.Name(), err, grouped[typeName]...)
+			if recordErr != nil {
+				logger.Info(fmt.Sprintf("could not record failed deliveries for %s: %s\n",
+					sink.Name(), recordErr))
+			}
+		}
+	}
+}
+
+// re-send whatever failed before for a sink (does not re-run validation
+// or transfer) - returns how many were delivered this time
+func RetryDeliveries(sinkName string) (int, error) {
+	sink, ok := sinkByName(sinkName)
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("no sink registered named %s", sinkName))
+	}
+	failed, err := RetrieveFailedDeliveries(sinkName)
+	if err != nil {
+		return 0, err
+	}
+	changes := make([]Change, 0)
+	for _, delivery := range failed {
+		changes = append(changes, delivery.Change)
+	}
+	delivered := 0
+	var sendErr error
+	typeNames, grouped := groupChangesByType(changes)
+	for _, typeName := range typeNames {
+		batch := MakeChangeBatch(typeName, grouped[typeName]...)
+		err = sink.Send(batch)
+		if err != nil {
+			sendErr = errors.Wrap(err, fmt.Sprintf("retrying %s deliveries to %s", typeName, sinkName))
+			recordErr := RecordFailedDeliveries(sinkName, err, grouped[typeName]...)
+			if recordErr != nil {
+				return delivered, recordErr
+			}
+			continue
+		}
+		err = ClearDeliveries(sinkName, grouped[typeName]...)
+		if err != nil {
+			return delivered, err
+		}
+		delivered += batch.Len()
+	}
+	return delivered, sendErr
+}
@@ -0,0 +1,239 @@
+package scramjet_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+// keeps everything it's sent
+type recordingSink struct {
+	name    string
+	batches []sj.ChangeBatch
+	fail    bool
+}
+
+func (s *recordingSink) Name() string {
+	return s.name
+}
+
+func (s *recordingSink) Send(batch sj.ChangeBatch) error {
+	if s.fail {
+		return errors.New("sink is down")
+	}
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func (s *recordingSink) count(op sj.ChangeOp) int {
+	total := 0
+	for _, batch := range s.batches {
+		switch op {
+		case sj.AddOp:
+			total += len(batch.Adds)
+		case sj.UpdateOp:
+			total += len(batch.Updates)
+		case sj.DeleteOp:
+			total += len(batch.Deletes)
+		}
+	}
+	return total
+}
+
+func TestSinkReceivesChanges(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	sj.ClearSinks()
+	defer sj.ClearSinks()
+	typeName := "person"
+
+	sink := &recordingSink{name: "recorder"}
+	sj.RegisterSink(sink, typeName)
+
+	alwaysOkay := func(json string) bool { return true }
+	person1 := TestPerson{Id: "per0000001", Name: "Test1"}
+	person2 := TestPerson{Id: "per0000002", Name: "Test2"}
+	err := sj.StashStaging(sj.MakePacket(person1.Id, typeName, person1),
+		sj.MakePacket(person2.Id, typeName, person2))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if sink.count(sj.AddOp) != 2 {
+		t.Errorf("sink should have 2 adds - not %d\n", sink.count(sj.AddOp))
+	}
+
+	// one changed, one the same
+	person1.Name = "Test1updated"
+	err = sj.StashStaging(sj.MakePacket(person1.Id, typeName, person1),
+		sj.MakePacket(person2.Id, typeName, person2))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if sink.count(sj.UpdateOp) != 1 {
+		t.Errorf("sink should have 1 update - not %d\n", sink.count(sj.UpdateOp))
+	}
+
+	_, err = sj.RemoveRecords(sj.MakeStub(person2.Id, typeName))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if sink.count(sj.DeleteOp) != 1 {
+		t.Errorf("sink should have 1 delete - not %d\n", sink.count(sj.DeleteOp))
+	}
+}
+
+func TestSinkRetry(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	sj.ClearAllDeliveries()
+	sj.ClearSinks()
+	defer sj.ClearSinks()
+	typeName := "person"
+
+	sink := &recordingSink{name: "flaky", fail: true}
+	sj.RegisterSink(sink)
+
+	alwaysOkay := func(json string) bool { return true }
+	person1 := TestPerson{Id: "per0000001", Name: "Test1"}
+	err := sj.StashStaging(sj.MakePacket(person1.Id, typeName, person1))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	// transfer still works even if sink does not
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if sj.FailedDeliveryCount("flaky") != 1 {
+		t.Errorf("should be 1 failed delivery - not %d\n", sj.FailedDeliveryCount("flaky"))
+	}
+
+	sink.fail = false
+	delivered, err := sj.RetryDeliveries("flaky")
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if delivered != 1 || sink.count(sj.AddOp) != 1 {
+		t.Errorf("retry should have delivered 1 add - not %d\n", delivered)
+	}
+	if sj.FailedDeliveryCount("flaky") != 0 {
+		t.Error("failed deliveries should be cleared after retry")
+	}
+}
+
+func TestSinkClearsStaleDeliveries(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	sj.ClearAllDeliveries()
+	sj.ClearSinks()
+	defer sj.ClearSinks()
+	typeName := "person"
+
+	sink := &recordingSink{name: "flaky", fail: true}
+	sj.RegisterSink(sink)
+
+	alwaysOkay := func(json string) bool { return true }
+	person1 := TestPerson{Id: "per0000001", Name: "Test1"}
+	err := sj.StashStaging(sj.MakePacket(person1.Id, typeName, person1))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	// NOTE: the newer version goes through - the old failure is out of date
+	sink.fail = false
+	person1.Name = "Test1 Changed"
+	err = sj.StashStaging(sj.MakePacket(person1.Id, typeName, person1))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if sj.FailedDeliveryCount("flaky") != 0 {
+		t.Errorf("stale delivery should be cleared - not %d\n", sj.FailedDeliveryCount("flaky"))
+	}
+	delivered, err := sj.RetryDeliveries("flaky")
+	if err != nil || delivered != 0 {
+		t.Errorf("nothing should be retried - not %d (err=%v)\n", delivered, err)
+	}
+}
+
+func TestSinkFailsTwice(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	sj.ClearAllDeliveries()
+	sj.ClearSinks()
+	defer sj.ClearSinks()
+	typeName := "person"
+
+	sink := &recordingSink{name: "flaky"}
+	sj.RegisterSink(sink)
+
+	alwaysOkay := func(json string) bool { return true }
+	transfer := func(people ...TestPerson) {
+		for _, person := range people {
+			err := sj.StashStaging(sj.MakePacket(person.Id, typeName, person))
+			if err != nil {
+				t.Errorf("err=%v\n", err)
+			}
+		}
+		_, err := sj.TransferAll(typeName, alwaysOkay)
+		if err != nil {
+			t.Errorf("err=%v\n", err)
+		}
+	}
+
+	// per0000001 v1 delivered, then v2 and v3 fail - per0000002 never gets there
+	transfer(TestPerson{Id: "per0000001", Name: "Test1 v1"})
+	sink.fail = true
+	transfer(TestPerson{Id: "per0000001", Name: "Test1 v2"}, TestPerson{Id: "per0000002", Name: "Test2 v1"})
+	transfer(TestPerson{Id: "per0000001", Name: "Test1 v3"}, TestPerson{Id: "per0000002", Name: "Test2 v2"})
+
+	deliveries, err := sj.RetrieveFailedDeliveries("flaky")
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(deliveries) != 2 {
+		t.Fatalf("should be 2 failed deliveries - not %d\n", len(deliveries))
+	}
+	for _, delivery := range deliveries {
+		change := delivery.Change
+		switch change.Id.Id {
+		case "per0000001":
+			// NOTE: the sink still has v1
+			if change.Op != sj.UpdateOp || !strings.Contains(string(change.PreviousData), "Test1 v1") ||
+				!strings.Contains(string(change.Data), "Test1 v3") {
+				t.Errorf("should be an update from v1 to v3 - not %s %s -> %s\n", change.Op,
+					change.PreviousData, change.Data)
+			}
+		case "per0000002":
+			if change.Op != sj.AddOp || !strings.Contains(string(change.Data), "Test2 v2") {
+				t.Errorf("should still be an add (of v2) - not %s %s\n", change.Op, change.Data)
+			}
+		}
+	}
+
+	_, err = sj.RemoveRecords(sj.MakeStub("per0000002", typeName))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if sj.FailedDeliveryCount("flaky") != 1 {
+		t.Errorf("add then delete should leave nothing to send - not %d\n",
+			sj.FailedDeliveryCount("flaky"))
+	}
+}
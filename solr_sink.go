@@ -0,0 +1,162 @@
+package scramjet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// turns a resource (add or update) into a solr document - nil (with no
+// error) skips it
+// NOTE: if the document has no "id" the resource id is used
+type SolrDocMapper func(change Change) (map[string]interface{}, error)
+
+type SolrCommitPolicy string
+
+const (
+	SolrCommitNone   SolrCommitPolicy = "none"   // leave it to solr autoCommit
+	SolrCommitBatch  SolrCommitPolicy = "batch"  // commit=true on every request
+	SolrCommitWithin SolrCommitPolicy = "within" // commitWithin (see CommitWithin)
+	SolrCommitEnd    SolrCommitPolicy = "end"    // one commit after each Send
+)
+
+type SolrSink struct {
+	SinkName     string
+	UpdateUrl    string // e.g. http://localhost:8983/solr/vivo/update
+	Mappers      map[string]SolrDocMapper
+	DeleteId     func(change Change) string
+	BatchSize    int
+	Commit       SolrCommitPolicy
+	CommitWithin time.Duration
+	Client       *http.Client
+}
+
+// only types with a mapper are sent to solr
+func MakeSolrSink(updateUrl string, mappers map[string]SolrDocMapper) *SolrSink {
+	return &SolrSink{
+		SinkName:  fmt.Sprintf("solr:%s", updateUrl),
+		UpdateUrl: updateUrl,
+		Mappers:   mappers,
+		BatchSize: 500,
+		Commit:    SolrCommitEnd,
+		Client:    &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (s *SolrSink) Name() string {
+	return s.SinkName
+}
+
+func (s *SolrSink) Send(batch ChangeBatch) error {
+	mapper, ok := s.Mappers[batch.TypeName]
+	if !ok {
+		return nil
+	}
+	docs := make([]map[string]interface{}, 0)
+	for _, list := range [][]Change{batch.Adds, batch.Updates} {
+		for _, change := range list {
+			doc, err := mapper(change)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("mapping %s to solr document", change.Id))
+			}
+			if doc == nil {
+				continue
+			}
+			if _, ok := doc["id"]; !ok {
+				doc["id"] = change.Id.Id
+			}
+			docs = append(docs, doc)
+		}
+	}
+	ids := make([]string, 0)
+	for _, change := range batch.Deletes {
+		ids = append(ids, s.deleteId(change))
+	}
+
+	size := s.BatchSize
+	if size <= 0 {
+		size = 500
+	}
+	for i := 0; i < len(docs); i += size {
+		end := i + size
+		if end > len(docs) {
+			end = len(docs)
+		}
+		err := s.post(docs[i:end])
+		if err != nil {
+			return errors.Wrap(err, "posting documents to solr")
+		}
+	}
+	for i := 0; i < len(ids); i += size {
+		end := i + size
+		if end > len(ids) {
+			end = len(ids)
+		}
+		err := s.post(map[string]interface{}{"delete": ids[i:end]})
+		if err != nil {
+			return errors.Wrap(err, "deleting documents from solr")
+		}
+	}
+	if s.Commit == SolrCommitEnd && len(docs)+len(ids) > 0 {
+		err := s.post(map[string]interface{}{"commit": map[string]interface{}{}})
+		if err != nil {
+			return errors.Wrap(err, "committing solr")
+		}
+	}
+	return nil
+}
+
+func (s *SolrSink) deleteId(change Change) string {
+	if s.DeleteId != nil {
+		return s.DeleteId(change)
+	}
+	return change.Id.Id
+}
+
+func (s *SolrSink) updateUrl() (string, error) {
+	u, err := url.Parse(s.UpdateUrl)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	switch s.Commit {
+	case SolrCommitBatch:
+		query.Set("commit", "true")
+	case SolrCommitWithin:
+		query.Set("commitWithin", strconv.FormatInt(s.CommitWithin.Milliseconds(), 10))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func (s *SolrSink) post(body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	target, err := s.updateUrl()
+	if err != nil {
+		return err
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(target, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("solr returned %d: %s", resp.StatusCode, msg))
+	}
+	return nil
+}
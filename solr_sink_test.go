@@ -0,0 +1,136 @@
+package scramjet_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+// stand-in for solr /update - just keeps the bodies posted
+func solrStandIn(t *testing.T, bodies *[]interface{}, queries *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := ioutil.ReadAll(r.Body)
+		var body interface{}
+		err := json.Unmarshal(raw, &body)
+		if err != nil {
+			t.Errorf("solr got invalid json:%s\n", raw)
+		}
+		*bodies = append(*bodies, body)
+		*queries = append(*queries, r.URL.RawQuery)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"responseHeader":{"status":0}}`))
+	}))
+}
+
+func TestSolrSink(t *testing.T) {
+	bodies := []interface{}{}
+	queries := []string{}
+	server := solrStandIn(t, &bodies, &queries)
+	defer server.Close()
+
+	mapper := func(change sj.Change) (map[string]interface{}, error) {
+		var person TestPerson
+		err := json.Unmarshal(change.Data, &person)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"name_t": person.Name}, nil
+	}
+	sink := sj.MakeSolrSink(server.URL+"/solr/people/update",
+		map[string]sj.SolrDocMapper{"person": mapper})
+	sink.BatchSize = 1
+
+	batch := sj.MakeChangeBatch("person",
+		sj.Change{Id: sj.Identifier{Id: "per0000001", Type: "person"}, Op: sj.AddOp,
+			Data: []byte(`{"id": "per0000001", "name": "Test1"}`)},
+		sj.Change{Id: sj.Identifier{Id: "per0000002", Type: "person"}, Op: sj.UpdateOp,
+			Data: []byte(`{"id": "per0000002", "name": "Test2"}`)},
+		sj.Change{Id: sj.Identifier{Id: "per0000003", Type: "person"}, Op: sj.DeleteOp},
+	)
+	err := sink.Send(batch)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	// 2 adds/updates (batch size 1), 1 delete, 1 commit
+	if len(bodies) != 4 {
+		t.Fatalf("should have posted 4 times to solr - not %d\n", len(bodies))
+	}
+	docs := bodies[0].([]interface{})
+	doc := docs[0].(map[string]interface{})
+	if doc["id"] != "per0000001" || doc["name_t"] != "Test1" {
+		t.Errorf("unexpected solr document %v\n", doc)
+	}
+	deletes := bodies[2].(map[string]interface{})["delete"].([]interface{})
+	if len(deletes) != 1 || deletes[0] != "per0000003" {
+		t.Errorf("unexpected solr delete %v\n", deletes)
+	}
+	if _, ok := bodies[3].(map[string]interface{})["commit"]; !ok {
+		t.Errorf("last post should be a commit - not %v\n", bodies[3])
+	}
+
+	// types without a mapper are skipped
+	err = sink.Send(sj.MakeChangeBatch("publication",
+		sj.Change{Id: sj.Identifier{Id: "pub0001", Type: "publication"}, Op: sj.AddOp}))
+	if err != nil || len(bodies) != 4 {
+		t.Errorf("should not post types without a mapper")
+	}
+}
+
+func TestSolrSinkCommitWithin(t *testing.T) {
+	bodies := []interface{}{}
+	queries := []string{}
+	server := solrStandIn(t, &bodies, &queries)
+	defer server.Close()
+
+	mapper := func(change sj.Change) (map[string]interface{}, error) {
+		return map[string]interface{}{}, nil
+	}
+	sink := sj.MakeSolrSink(server.URL+"/update", map[string]sj.SolrDocMapper{"person": mapper})
+	sink.Commit = sj.SolrCommitWithin
+	sink.CommitWithin = 5 * time.Second
+
+	err := sink.Send(sj.MakeChangeBatch("person",
+		sj.Change{Id: sj.Identifier{Id: "per0000001", Type: "person"}, Op: sj.AddOp}))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(queries) != 1 || queries[0] != "commitWithin=5000" {
+		t.Errorf("expected a single post with commitWithin - got %v\n", queries)
+	}
+}
+
+func TestSolrSinkSkip(t *testing.T) {
+	bodies := []interface{}{}
+	queries := []string{}
+	server := solrStandIn(t, &bodies, &queries)
+	defer server.Close()
+
+	// NOTE: nil means leave it out of solr
+	mapper := func(change sj.Change) (map[string]interface{}, error) {
+		if change.Id.Id == "per0000002" {
+			return nil, nil
+		}
+		return map[string]interface{}{}, nil
+	}
+	sink := sj.MakeSolrSink(server.URL+"/update", map[string]sj.SolrDocMapper{"person": mapper})
+	sink.Commit = sj.SolrCommitNone
+
+	err := sink.Send(sj.MakeChangeBatch("person",
+		sj.Change{Id: sj.Identifier{Id: "per0000001", Type: "person"}, Op: sj.AddOp},
+		sj.Change{Id: sj.Identifier{Id: "per0000002", Type: "person"}, Op: sj.AddOp}))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(bodies) != 1 {
+		t.Fatalf("should have posted once - not %d\n", len(bodies))
+	}
+	docs := bodies[0].([]interface{})
+	if len(docs) != 1 || docs[0].(map[string]interface{})["id"] != "per0000001" {
+		t.Errorf("only per0000001 should be posted - not %v\n", docs)
+	}
+}
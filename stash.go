@@ -2,6 +2,9 @@ package scramjet
 
 import (
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -9,167 +12,397 @@ import (
 // the parameter (int) is 'offset'
 type IntakeListMaker func(int) ([]Storeable, error)
 
+// NOTE: either a ListMaker (with Count and ChunkSize if it pages) or a
+// Source (see TokenSource) for sources that don't say how many there are
 type IntakeConfig struct {
 	TypeName  string
 	ListMaker IntakeListMaker
 	Count     int
 	ChunkSize int
+	Source    IntakeSource
+	// NOTE: these are optional
+	Concurrency int           // chunks fetched at once - default 1
+	Retries     int           // more tries of a chunk's ListMaker after it fails
+	Backoff     time.Duration // wait after first failure, doubles each time (default 1s)
+	// name to record finished chunks under (see RetrieveIntakeCheckpoints)
+	// so a rerun after a failure skips them
+	Checkpoint string
 }
 
 type TrajectConfig struct {
 	TypeName  string
 	Validator ValidatorFunc
 	Filter    *Filter
+	Mode      TransferMode
+	// NOTE: covers intake too (see Scramjet) - nothing is saved
+	DryRun bool
 }
 
 type OutakeConfig struct {
 	TypeName  string
 	ListMaker OutakeListMaker
 	Filter    *Filter
+	DryRun    bool
+	// Token from a BlockedDeletesError - to go over the DeleteThreshold
+	OverrideToken string
 }
 
-func Scramjet(in IntakeConfig, process TrajectConfig, out OutakeConfig) error {
-	err := Inject(in)
+// NOTE: if either process or out is a DryRun the whole thing is
+// (no deletes based on an intake that didn't happen)
+func Scramjet(in IntakeConfig, process TrajectConfig, out OutakeConfig) (TransferSummary, error) {
+	lock, err := LockTypes(withDependentTypes(in.TypeName, process.TypeName, out.TypeName)...)
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
-	err = Traject(process)
+	defer lock.Unlock()
+	return scramjet(in, process, out)
+}
+
+func scramjet(in IntakeConfig, process TrajectConfig, out OutakeConfig) (TransferSummary, error) {
+	if process.DryRun || out.DryRun {
+		process.DryRun = true
+		out.DryRun = true
+	}
+	summary, err := scramjetIntake(in, process)
 	if err != nil {
-		return err
+		return summary, err
 	}
-	err = Eject(out)
+	removed, err := eject(out)
+	summary.Merge(removed)
 	if err != nil {
-		return err
+		return summary, err
 	}
-	return nil
+	return summary, nil
 }
 
-func ScramjetIntake(in IntakeConfig, process TrajectConfig) error {
-	err := Inject(in)
+func ScramjetIntake(in IntakeConfig, process TrajectConfig) (TransferSummary, error) {
+	lock, err := LockTypes(in.TypeName, process.TypeName)
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
-	err = Traject(process)
+	defer lock.Unlock()
+	return scramjetIntake(in, process)
+}
+
+func scramjetIntake(in IntakeConfig, process TrajectConfig) (TransferSummary, error) {
+	if process.DryRun {
+		return dryRunTransfer(&in, process)
+	}
+	err := IntakeInChunks(in)
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
-	return nil
+	return traject(process)
+}
+
+func ScramjetOutake(out OutakeConfig) (TransferSummary, error) {
+	return Eject(out)
 }
 
-func ScramjetOutake(out OutakeConfig) error {
-	err := Eject(out)
+func Inject(config IntakeConfig) error {
+	lock, err := LockTypes(config.TypeName)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer lock.Unlock()
+	return IntakeInChunks(config)
 }
 
-func Inject(config IntakeConfig) error {
-	return IntakeInChunks(config)
+func Traject(config TrajectConfig) (TransferSummary, error) {
+	lock, err := LockTypes(config.TypeName)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	defer lock.Unlock()
+	return traject(config)
 }
 
-func Traject(config TrajectConfig) error {
+func traject(config TrajectConfig) (TransferSummary, error) {
+	if config.DryRun {
+		return dryRunTransfer(nil, config)
+	}
+	switch config.Mode {
+	case TransferAtomic:
+		return transferAtomic(config.TypeName, config.Filter, config.Validator)
+	case TransferServerSide:
+		return transferServerSide(config.TypeName, config.Filter, config.Validator)
+	}
 	if config.Filter != nil {
-		return TransferSubset(config.TypeName, *config.Filter, config.Validator)
+		return transferSubset(config.TypeName, *config.Filter, config.Validator)
 	} else {
-		return TransferAll(config.TypeName, config.Validator)
+		return transferAll(config.TypeName, config.Validator)
 	}
 }
 
-func Eject(config OutakeConfig) error {
-	err := ProcessOutake(config)
+func Eject(config OutakeConfig) (TransferSummary, error) {
+	lock, err := LockTypes(withDependentTypes(config.TypeName)...)
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
-	// how to differentiate diff, with out-take?
-	if config.Filter != nil {
-		// NOTE: right now json is {} so no way to actually filter
-		err = BulkRemoveStagingDeletedFromResources(config.TypeName)
-	} else {
-		err = BulkRemoveStagingDeletedFromResources(config.TypeName)
+	defer lock.Unlock()
+	return eject(config)
+}
+
+func eject(config OutakeConfig) (TransferSummary, error) {
+	if config.DryRun {
+		return dryRunRemove(config)
 	}
+	_, err := processDiff(outakeDiffConfig(config))
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
-	return nil
+	// how to differentiate diff, with out-take?
+	// NOTE: right now json is {} so no way to actually filter
+	// (with or without config.Filter)
+	return removeStagingDeletedFromResources(config.TypeName)
+}
+
+func TransferAll(typeName string, validator ValidatorFunc) (TransferSummary, error) {
+	lock, err := LockTypes(typeName)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	defer lock.Unlock()
+	return transferAll(typeName, validator)
 }
 
-func TransferAll(typeName string, validator ValidatorFunc) error {
+func transferAll(typeName string, validator ValidatorFunc) (TransferSummary, error) {
 	err := ProcessTypeStaging(typeName, validator)
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
 	staging, err := RetrieveValidStaging(typeName)
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
-	err = BulkMoveStagingTypeToResources(typeName, staging...)
+	summary, err := moveStagingTypeToResources(typeName, staging...)
 	if err != nil {
-		return err
+		return summary, err
 	}
-	return nil
+	GetLogger().Debug(fmt.Sprintf("> transferred %s: %s\n", typeName, summary))
+	return summary, nil
 }
 
-func TransferSubset(typeName string, filter Filter, validator ValidatorFunc) error {
+func TransferSubset(typeName string, filter Filter, validator ValidatorFunc) (TransferSummary, error) {
+	lock, err := LockTypes(typeName)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	defer lock.Unlock()
+	return transferSubset(typeName, filter, validator)
+}
+
+func transferSubset(typeName string, filter Filter, validator ValidatorFunc) (TransferSummary, error) {
 	err := ProcessTypeStagingFiltered(typeName, filter, validator)
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
 	staging, err := RetrieveValidStagingFiltered(typeName, filter)
 	if err != nil {
-		return err
+		return TransferSummary{}, err
 	}
-	err = BulkMoveStagingToResourcesByFilter(typeName, filter, staging...)
+	summary, err := moveStagingToResourcesByFilter(typeName, filter, staging...)
 	if err != nil {
-		return err
+		return summary, err
 	}
-	return nil
+	GetLogger().Debug(fmt.Sprintf("> transferred %s subset: %s\n", typeName, summary))
+	return summary, nil
 }
 
+// NOTE: runs the type's transforms (see StageTransformed) - a record
+// that fails one doesn't stop the rest
 func IntakeInChunks(ins IntakeConfig) error {
-	var err error
-	var logger = GetLogger()
+	return eachIntakeChunk(ins, func(list []Storeable) error {
+		_, err := StageTransformed(list...)
+		return err
+	})
+}
+
+// a chunk (by offset) that could not be fetched or staged - with a
+// Source, Offset is the page number and Token what it was called with
+type ChunkFailure struct {
+	Offset int
+	Token  string
+	Err    error
+}
 
+// NOTE: the other chunks were staged (and checkpointed, if there is one)
+type IntakeChunksError struct {
+	TypeName string
+	Failed   []ChunkFailure
+}
+
+func (e IntakeChunksError) Error() string {
+	first := e.Failed[0]
+	return fmt.Sprintf("%d %s chunks failed (first is offset %d: %s)", len(e.Failed),
+		e.TypeName, first.Offset, first.Err)
+}
+
+// the offsets to call ListMaker with - just 0 if there's no Count
+func intakeOffsets(ins IntakeConfig) []int {
 	if ins.Count == 0 {
-		msg := fmt.Sprintf("> retrieving records of %s in one call\n", ins.TypeName)
-		logger.Debug(msg)
-		offset := 0
-		// just start at first record
-		list, err := ins.ListMaker(offset)
+		return []int{0}
+	}
+	chunkSize := ins.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = ins.Count
+	}
+	offsets := []int{}
+	for i := 0; i < ins.Count; i += chunkSize {
+		offsets = append(offsets, i)
+	}
+	return offsets
+}
+
+// calls ListMaker (in chunks if there is a Count, Concurrency at a time)
+// and hands each list to stash - a chunk that fails (after Retries) doesn't
+// stop the others, they come back together as an IntakeChunksError
+// NOTE: stash is only called one at a time
+func eachIntakeChunk(ins IntakeConfig, stash func([]Storeable) error) error {
+	var logger = GetLogger()
+	if ins.Source != nil {
+		return eachSourcePage(ins, stash)
+	}
+	if ins.ListMaker == nil {
+		return errors.New(fmt.Sprintf("no ListMaker or Source for %s intake", ins.TypeName))
+	}
+
+	offsets := intakeOffsets(ins)
+	if len(ins.Checkpoint) > 0 {
+		completed, err := RetrieveIntakeCheckpoints(ins)
 		if err != nil {
 			return err
 		}
-		err = BulkAddStaging(list...)
-		if err != nil {
-			return err
+		remaining := []int{}
+		for _, offset := range offsets {
+			if !containsInt(completed, offset) {
+				remaining = append(remaining, offset)
+			}
 		}
-		msg = fmt.Sprintf("> retrieved %d records\n", len(list))
-		logger.Debug(msg)
+		if len(remaining) < len(offsets) {
+			logger.Info(fmt.Sprintf("> skipping %d %s chunks already done (%s)\n",
+				len(offsets)-len(remaining), ins.TypeName, ins.Checkpoint))
+		}
+		offsets = remaining
+	}
 
-	} else {
-		for i := 0; i < ins.Count; i += ins.ChunkSize {
-			msg := fmt.Sprintf("> retrieving %d-%d of %d\n", i, i+ins.ChunkSize, ins.Count)
-			logger.Debug(msg)
-			list, err := ins.ListMaker(i)
-			if err != nil {
-				return err
-			}
-			err = BulkAddStaging(list...)
-			if err != nil {
-				return err
+	concurrency := ins.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var stashMutex sync.Mutex
+	var failedMutex sync.Mutex
+	failed := []ChunkFailure{}
+	offsetQueue := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for offset := range offsetQueue {
+				err := intakeChunk(ins, offset, stash, &stashMutex)
+				if err != nil {
+					logger.Info(fmt.Sprintf("> %s chunk at %d failed: %s\n", ins.TypeName, offset, err))
+					failedMutex.Lock()
+					failed = append(failed, ChunkFailure{Offset: offset, Err: err})
+					failedMutex.Unlock()
+				}
 			}
+		}()
+	}
+	for _, offset := range offsets {
+		offsetQueue <- offset
+	}
+	close(offsetQueue)
+	wg.Wait()
+
+	if len(failed) > 0 {
+		sort.Slice(failed, func(i, j int) bool { return failed[i].Offset < failed[j].Offset })
+		return IntakeChunksError{TypeName: ins.TypeName, Failed: failed}
+	}
+	logger.Debug(fmt.Sprintf("> finished %s records\n", ins.TypeName))
+	// NOTE: all done - so the next run starts over
+	if len(ins.Checkpoint) > 0 {
+		return ClearIntakeCheckpoints(ins.Checkpoint, ins.TypeName)
+	}
+	return nil
+}
+
+func intakeChunk(ins IntakeConfig, offset int, stash func([]Storeable) error, stashMutex *sync.Mutex) error {
+	var logger = GetLogger()
+	if ins.Count == 0 {
+		logger.Debug(fmt.Sprintf("> retrieving records of %s in one call\n", ins.TypeName))
+	} else {
+		logger.Debug(fmt.Sprintf("> retrieving %d-%d of %d\n", offset, offset+ins.ChunkSize, ins.Count))
+	}
+	list, err := fetchChunk(ins, offset)
+	if err != nil {
+		return err
+	}
+	stashMutex.Lock()
+	err = stash(list)
+	stashMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	logger.Debug(fmt.Sprintf("> retrieved %d records\n", len(list)))
+	if len(ins.Checkpoint) > 0 {
+		return recordIntakeCheckpoint(ins, offset)
+	}
+	return nil
+}
+
+// ListMaker, tried again (Retries times) if it fails
+func fetchChunk(ins IntakeConfig, offset int) ([]Storeable, error) {
+	var list []Storeable
+	err := withRetries(ins, func() error {
+		var err error
+		list, err = ins.ListMaker(offset)
+		return err
+	})
+	return list, err
+}
+
+// waits Backoff (doubling) between tries
+func withRetries(ins IntakeConfig, fetch func() error) error {
+	wait := ins.Backoff
+	if wait <= 0 {
+		wait = time.Second
+	}
+	var err error
+	for attempt := 0; attempt <= ins.Retries; attempt++ {
+		err = fetch()
+		if err == nil {
+			return nil
+		}
+		if attempt < ins.Retries {
+			time.Sleep(wait)
+			wait = wait * 2
 		}
-		logger.Debug(fmt.Sprintf("> finished %s records\n", ins.TypeName))
 	}
 	return err
 }
 
+func containsInt(list []int, value int) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
 // maybe interface instead of func type in struct?
 type OutakeListMaker func() ([]string, error)
 
 type ResourceListMaker func() ([]Resource, error)
 
-func ProcessOutake(config OutakeConfig) error {
+// NOTE: summary is what was flagged for delete (Eject does the removing)
+func ProcessOutake(config OutakeConfig) (TransferSummary, error) {
+	return ProcessDiff(outakeDiffConfig(config))
+}
+
+func outakeDiffConfig(config OutakeConfig) DiffProcessConfig {
 	// NOTE: for comparing source data of *all* with existing *all*
 	var existing ExistingListMaker
 	var diffConfig DiffProcessConfig
@@ -194,8 +427,9 @@ func ProcessOutake(config OutakeConfig) error {
 			AllowDeleteAll:    false,
 		}
 	}
-
-	return ProcessDiff(diffConfig)
+	diffConfig.DryRun = config.DryRun
+	diffConfig.OverrideToken = config.OverrideToken
+	return diffConfig
 }
 
 type ExistingListMaker func() ([]Resource, error)
@@ -206,36 +440,81 @@ type DiffProcessConfig struct {
 	ExistingListMaker ExistingListMaker
 	ListMaker         OutakeListMaker
 	AllowDeleteAll    bool
+	DryRun            bool // only find them, don't flag in staging
+	// Token from a BlockedDeletesError - to go over the DeleteThreshold
+	OverrideToken string
+}
+
+// NOTE: summary is what was flagged for delete (or would be with DryRun)
+func ProcessDiff(config DiffProcessConfig) (TransferSummary, error) {
+	lock, err := LockTypes(config.TypeName)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	defer lock.Unlock()
+	return processDiff(config)
+}
+
+func processDiff(config DiffProcessConfig) (TransferSummary, error) {
+	deletes, err := diffDeletes(config)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	summary := summarizeDeletes(deletes)
+	if config.DryRun {
+		return summary, nil
+	}
+	err = BulkAddStagingForDelete(deletes...)
+	if err != nil {
+		msg := fmt.Sprintf("could not mark for delete: %s", err)
+		return TransferSummary{}, errors.New(msg)
+	}
+	return summary, nil
 }
 
-func ProcessDiff(config DiffProcessConfig) error {
+func diffDeletes(config DiffProcessConfig) ([]Identifiable, error) {
 	sourceData, err := config.ListMaker()
 	if err != nil {
 		msg := fmt.Sprintf("couldn't make list sent in for %s\n", config.TypeName)
-		return errors.New(msg)
+		return nil, errors.New(msg)
 	}
 
 	resources, err := config.ExistingListMaker()
 
 	if err != nil {
 		msg := fmt.Sprintf("couldn't retrieve list of %s\n", config.TypeName)
-		return errors.New(msg)
+		return nil, errors.New(msg)
 	}
-	return FlagDeletes(sourceData, resources, config)
+	return findDeletes(sourceData, resources, config)
 }
 
 func FlagDeletes(sourceDataIds []string, existingData []Resource, config DiffProcessConfig) error {
+	deletes, err := findDeletes(sourceDataIds, existingData, config)
+	if err != nil {
+		return err
+	}
+	err = BulkAddStagingForDelete(deletes...)
+	if err != nil {
+		msg := fmt.Sprintf("could not mark for delete: %s", err)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// what is in existing but not in source
+func findDeletes(sourceDataIds []string, existingData []Resource, config DiffProcessConfig) ([]Identifiable, error) {
 	typeName := config.TypeName
 
 	destData := make([]string, 0)
+	deletes := make([]Identifiable, 0)
 
 	if len(sourceDataIds) == 0 && len(existingData) > 0 && !config.AllowDeleteAll {
 		msg := fmt.Sprintf("0 source records found - this would delete all %s records!\n", typeName)
-		return errors.New(msg)
+		return deletes, errors.New(msg)
 	} else if len(sourceDataIds) == 0 && len(existingData) == 0 {
 		msg := "0 record to compare on either side!"
 		GetLogger().Info(msg)
-		return nil
+		return deletes, nil
 	}
 
 	if len(existingData) > 0 {
@@ -244,7 +523,7 @@ func FlagDeletes(sourceDataIds []string, existingData []Resource, config DiffPro
 		// any list of ids
 		if peek.Type != typeName {
 			msg := fmt.Sprintf("unexpected type in existing data (%s vs %s)!\n", peek.Type, typeName)
-			return errors.New(msg)
+			return deletes, errors.New(msg)
 		}
 	}
 
@@ -255,18 +534,16 @@ func FlagDeletes(sourceDataIds []string, existingData []Resource, config DiffPro
 
 	GetLogger().Debug(fmt.Sprintf("found =%d extras\n", len(extras)))
 
-	deletes := make([]Identifiable, 0)
 	for _, id := range extras {
 		// how to get type?
 		deletes = append(deletes, Stub{Id: Identifier{Id: id, Type: typeName}})
 	}
-	err := BulkAddStagingForDelete(deletes...)
+	// NOTE: e.g. a source fetch that only got some of the ids
+	err := checkDeleteThreshold(typeName, deletes, len(existingData), config.OverrideToken)
 	if err != nil {
-		msg := fmt.Sprintf("could not mark for delete: %s", err)
-		return errors.New(msg)
+		return make([]Identifiable, 0), err
 	}
-	// return something else? counts? entire list?
-	return nil
+	return deletes, nil
 }
 
 func MakePacket(id string, typeName string, obj interface{}) Packet {
@@ -282,27 +559,36 @@ func MakeStub(id string, typeName string) Stub {
 	}
 }
 
-func RemoveRecords(stubs ...Stub) error {
+func RemoveRecords(stubs ...Stub) (TransferSummary, error) {
 	// turn it into 'identifiable' list
 	var ids []Identifiable
+	var typeNames []string
 	for _, s := range stubs {
 		ids = append(ids, s)
+		typeNames = append(typeNames, s.Identifier().Type)
 	}
+	// NOTE: types a delete could cascade to are locked too
+	lock, err := LockTypes(withDependentTypes(typeNames...)...)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	defer lock.Unlock()
+
 	// 1. add as 'deletes' to staging
-	err := BulkAddStagingForDelete(ids...)
+	err = BulkAddStagingForDelete(ids...)
 	if err != nil {
-		return errors.Wrap(err, "could not mark records for delete")
+		return TransferSummary{}, errors.Wrap(err, "could not mark records for delete")
 	}
 	// 2. remove from resources
-	err = BatchDeleteStagingFromResources(ids...)
+	summary, err := deleteStagingFromResources(ids...)
 	if err != nil {
-		return errors.Wrap(err, "could not delete records")
+		return summary, errors.Wrap(err, "could not delete records")
 	}
 	// 3. remove from staging (so not hanging around)
 	err = ClearMultipleDeletedFromStaging(ids...)
 	if err != nil {
-		return errors.Wrap(err, "could not delete records from staging table")
+		return summary, errors.Wrap(err, "could not delete records from staging table")
 	}
 
-	return nil
+	return summary, nil
 }
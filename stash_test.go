@@ -1,7 +1,12 @@
 package scramjet_test
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	sj "github.com/OIT-ADS-Web/scramjet"
 )
@@ -61,7 +66,7 @@ func TestFullIntake(t *testing.T) {
 	outake := sj.OutakeConfig{TypeName: typeName, ListMaker: ids}
 
 	// main function to do all 3 in one sequence
-	err := sj.Scramjet(intake, move, outake)
+	_, err := sj.Scramjet(intake, move, outake)
 
 	if err != nil {
 		t.Errorf("err=%v\n", err)
@@ -100,7 +105,7 @@ func TestUpdatesIntake(t *testing.T) {
 	intake := sj.IntakeConfig{TypeName: typeName, Count: 2, ChunkSize: 1, ListMaker: listMaker}
 	move := sj.TrajectConfig{TypeName: typeName, Validator: alwaysOkay}
 
-	err := sj.ScramjetIntake(intake, move)
+	_, err := sj.ScramjetIntake(intake, move)
 
 	if err != nil {
 		t.Errorf("err=%v\n", err)
@@ -140,7 +145,7 @@ func TestEjectIntake(t *testing.T) {
 	move := sj.TrajectConfig{TypeName: typeName, Validator: alwaysOkay}
 
 	// only import
-	err := sj.ScramjetIntake(intake, move)
+	_, err := sj.ScramjetIntake(intake, move)
 
 	if err != nil {
 		t.Errorf("err=%v\n", err)
@@ -155,7 +160,7 @@ func TestEjectIntake(t *testing.T) {
 	outake := sj.OutakeConfig{TypeName: typeName, ListMaker: ids}
 
 	// then only removing
-	err = sj.ScramjetOutake(outake)
+	_, err = sj.ScramjetOutake(outake)
 	if err != nil {
 		t.Errorf("err=%v\n", err)
 	}
@@ -197,7 +202,7 @@ func TestFilteredIntake(t *testing.T) {
 	// filter is only moving one record
 	move := sj.TrajectConfig{TypeName: typeName, Validator: alwaysOkay, Filter: &filter}
 
-	err := sj.ScramjetIntake(intake, move)
+	_, err := sj.ScramjetIntake(intake, move)
 
 	if err != nil {
 		t.Errorf("err=%v\n", err)
@@ -238,7 +243,7 @@ func TestDefaultedIntake(t *testing.T) {
 	intake := sj.IntakeConfig{TypeName: typeName, ListMaker: listMaker}
 	move := sj.TrajectConfig{TypeName: typeName, Validator: alwaysOkay}
 
-	err := sj.ScramjetIntake(intake, move)
+	_, err := sj.ScramjetIntake(intake, move)
 
 	if err != nil {
 		t.Errorf("err=%v\n", err)
@@ -277,14 +282,14 @@ func TestRemoveByStub(t *testing.T) {
 	intake := sj.IntakeConfig{TypeName: typeName, ListMaker: listMaker}
 	move := sj.TrajectConfig{TypeName: typeName, Validator: alwaysOkay}
 
-	err := sj.ScramjetIntake(intake, move)
+	_, err := sj.ScramjetIntake(intake, move)
 	if err != nil {
 		t.Errorf("err=%v\n", err)
 	}
 	// should have two records now ...
 	// try removing one
 	stub := sj.MakeStub("per0000001", "person")
-	err = sj.RemoveRecords(stub)
+	_, err = sj.RemoveRecords(stub)
 	count := sj.ResourceCount(typeName)
 	if count != 1 {
 		t.Errorf("after remove should be 1 record - not :%d\n", count)
@@ -319,7 +324,7 @@ func TestRemoveMultiple(t *testing.T) {
 	intake := sj.IntakeConfig{TypeName: typeName, ListMaker: listMaker}
 	move := sj.TrajectConfig{TypeName: typeName, Validator: alwaysOkay}
 
-	err := sj.ScramjetIntake(intake, move)
+	_, err := sj.ScramjetIntake(intake, move)
 	if err != nil {
 		t.Errorf("err=%v\n", err)
 	}
@@ -330,7 +335,7 @@ func TestRemoveMultiple(t *testing.T) {
 	stub2 := sj.MakeStub("per0000002", "person")
 	stubs = append(stubs, stub1, stub2)
 
-	err = sj.RemoveRecords(stubs...)
+	_, err = sj.RemoveRecords(stubs...)
 	count := sj.ResourceCount(typeName)
 	if count != 1 {
 		t.Errorf("after remove should be 1 record - not :%d\n", count)
@@ -363,7 +368,7 @@ func TestRemoveAll(t *testing.T) {
 	intake := sj.IntakeConfig{TypeName: typeName, ListMaker: listMaker}
 	move := sj.TrajectConfig{TypeName: typeName, Validator: alwaysOkay}
 
-	err := sj.ScramjetIntake(intake, move)
+	_, err := sj.ScramjetIntake(intake, move)
 	if err != nil {
 		t.Errorf("err=%v\n", err)
 	}
@@ -390,7 +395,7 @@ func TestRemoveAll(t *testing.T) {
 	move2 := sj.TrajectConfig{TypeName: typeName2, Validator: alwaysOkay}
 
 	// import news records in
-	err = sj.ScramjetIntake(intake2, move2)
+	_, err = sj.ScramjetIntake(intake2, move2)
 	if err != nil {
 		t.Errorf("err=%v\n", err)
 	}
@@ -411,7 +416,7 @@ func TestRemoveAll(t *testing.T) {
 		ListMaker: ids,
 		Filter:    &filter,
 	}
-	err = sj.Eject(out)
+	_, err = sj.Eject(out)
 	if err != nil {
 		t.Errorf("err=%v\n", err)
 	}
@@ -452,7 +457,7 @@ func TestApostropheIdIntake(t *testing.T) {
 	intake := sj.IntakeConfig{TypeName: typeName, ListMaker: listMaker}
 	move := sj.TrajectConfig{TypeName: typeName, Validator: alwaysOkay}
 
-	err := sj.ScramjetIntake(intake, move)
+	_, err := sj.ScramjetIntake(intake, move)
 
 	if err != nil {
 		t.Errorf("err=%v\n", err)
@@ -463,3 +468,340 @@ func TestApostropheIdIntake(t *testing.T) {
 		t.Errorf("after import should be 1 record(s) - not :%d\n", count)
 	}
 }
+
+func TestTransferSummary(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+
+	sj.GetConfig().SummaryIds = true
+	defer func() { sj.GetConfig().SummaryIds = false }()
+
+	people := []IntakePerson{{Id: "per0000001", Name: "Test1"},
+		{Id: "per0000002", Name: "Test2"}}
+	listMaker := func(i int) ([]sj.Storeable, error) {
+		var list []sj.Storeable
+		for _, person := range people {
+			list = append(list, sj.MakePacket(person.Id, typeName, person))
+		}
+		return list, nil
+	}
+	ids := func() ([]string, error) {
+		var ids []string
+		for _, person := range people {
+			ids = append(ids, person.Id)
+		}
+		return ids, nil
+	}
+
+	alwaysOkay := func(json string) bool { return true }
+	intake := sj.IntakeConfig{TypeName: typeName, ListMaker: listMaker}
+	move := sj.TrajectConfig{TypeName: typeName, Validator: alwaysOkay}
+	outake := sj.OutakeConfig{TypeName: typeName, ListMaker: ids}
+
+	summary, err := sj.Scramjet(intake, move, outake)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Inserted != 2 || summary.Changed() != 2 {
+		t.Errorf("first run should insert 2 - not %s\n", summary)
+	}
+
+	// one updated, one new - and per0000002 gone
+	people = []IntakePerson{{Id: "per0000001", Name: "Test1updated"},
+		{Id: "per0000003", Name: "Test3"}}
+	summary, err = sj.Scramjet(intake, move, outake)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Inserted != 1 || summary.Updated != 1 || summary.Deleted != 1 {
+		t.Errorf("unexpected summary %s\n", summary)
+	}
+	if len(summary.DeletedIds) != 1 || summary.DeletedIds[0].Id != "per0000002" {
+		t.Errorf("per0000002 should be deleted - not %v\n", summary.DeletedIds)
+	}
+
+	// nothing different
+	summary, err = sj.ScramjetIntake(intake, move)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Unchanged != 2 || summary.Changed() != 0 {
+		t.Errorf("second run should leave 2 unchanged - not %s\n", summary)
+	}
+}
+
+func TestScramjetDryRun(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+
+	people := []IntakePerson{{Id: "per0000001", Name: "Test1"},
+		{Id: "per0000002", Name: "Test2"}}
+	listMaker := func(i int) ([]sj.Storeable, error) {
+		var list []sj.Storeable
+		for _, person := range people {
+			list = append(list, sj.MakePacket(person.Id, typeName, person))
+		}
+		return list, nil
+	}
+	ids := func() ([]string, error) {
+		var ids []string
+		for _, person := range people {
+			ids = append(ids, person.Id)
+		}
+		return ids, nil
+	}
+
+	alwaysOkay := func(json string) bool { return true }
+	intake := sj.IntakeConfig{TypeName: typeName, ListMaker: listMaker}
+	move := sj.TrajectConfig{TypeName: typeName, Validator: alwaysOkay}
+	outake := sj.OutakeConfig{TypeName: typeName, ListMaker: ids}
+
+	_, err := sj.Scramjet(intake, move, outake)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	people = []IntakePerson{{Id: "per0000001", Name: "Test1updated"},
+		{Id: "per0000003", Name: "Test3"}}
+	move.DryRun = true
+	summary, err := sj.Scramjet(intake, move, outake)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Inserted != 1 || summary.Updated != 1 || summary.Deleted != 1 {
+		t.Errorf("unexpected dry run summary %s\n", summary)
+	}
+
+	// nothing should have actually happened
+	count := sj.ResourceCount(typeName)
+	if count != 2 {
+		t.Errorf("dry run should leave 2 records - not %d\n", count)
+	}
+	staged := sj.StagingCount()
+	if staged != 0 {
+		t.Errorf("dry run should leave staging empty - not %d\n", staged)
+	}
+	res, _ := sj.RetrieveSingleResource("per0000001", typeName)
+	if strings.Contains(string(res.Data.Bytes), "updated") {
+		t.Errorf("dry run should not have updated per0000001\n")
+	}
+}
+
+func TestDryRunSkipsDeletes(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+	alwaysOkay := func(json string) bool { return true }
+
+	person1 := IntakePerson{Id: "per0000001", Name: "Test1"}
+	err := sj.StashStaging(sj.MakePacket(person1.Id, typeName, person1))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	// NOTE: flagged for delete - its data is {}, not an update
+	err = sj.BulkAddStagingForDelete(sj.MakeStub(person1.Id, typeName))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	summary, err := sj.Traject(sj.TrajectConfig{TypeName: typeName, Validator: alwaysOkay, DryRun: true})
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Inserted != 0 || summary.Updated != 0 {
+		t.Errorf("the delete should not be counted - not %s\n", summary)
+	}
+}
+
+func TestConcurrentIntake(t *testing.T) {
+	sj.ClearAllStaging()
+	typeName := "person"
+	checkpoint := "test-checkpoint"
+	defer sj.ClearIntakeCheckpoints(checkpoint, typeName)
+
+	var mutex sync.Mutex
+	calls := make(map[int]int)
+	broken := true
+	listMaker := func(offset int) ([]sj.Storeable, error) {
+		mutex.Lock()
+		calls[offset]++
+		tries := calls[offset]
+		mutex.Unlock()
+		// NOTE: 2 works on the retry, 4 only once it's fixed
+		if (offset == 2 && tries == 1) || (offset == 4 && broken) {
+			return nil, errors.New("source unavailable")
+		}
+		list := []sj.Storeable{}
+		for i := offset; i < offset+2; i++ {
+			id := fmt.Sprintf("per%07d", i)
+			list = append(list, sj.MakePacket(id, typeName, IntakePerson{Id: id, Name: "Test"}))
+		}
+		return list, nil
+	}
+	intake := sj.IntakeConfig{TypeName: typeName, ListMaker: listMaker, Count: 6, ChunkSize: 2,
+		Concurrency: 3, Retries: 1, Backoff: time.Millisecond, Checkpoint: checkpoint}
+
+	err := sj.IntakeInChunks(intake)
+	chunksErr, ok := err.(sj.IntakeChunksError)
+	if !ok || len(chunksErr.Failed) != 1 || chunksErr.Failed[0].Offset != 4 {
+		t.Errorf("only the chunk at 4 should fail - not %v\n", err)
+	}
+	list, err := sj.RetrieveTypeStaging(typeName)
+	if err != nil || len(list) != 4 {
+		t.Errorf("the other chunks should be staged - not %d (err=%v)\n", len(list), err)
+	}
+	offsets, err := sj.RetrieveIntakeCheckpoints(intake)
+	if err != nil || len(offsets) != 2 {
+		t.Errorf("should be 2 checkpoints - not %v (err=%v)\n", offsets, err)
+	}
+
+	broken = false
+	calls = make(map[int]int)
+	err = sj.IntakeInChunks(intake)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(calls) != 1 || calls[4] != 1 {
+		t.Errorf("rerun should only fetch the chunk at 4 - not %v\n", calls)
+	}
+	list, err = sj.RetrieveTypeStaging(typeName)
+	if err != nil || len(list) != 6 {
+		t.Errorf("should have staged all 6 - not %d (err=%v)\n", len(list), err)
+	}
+	offsets, err = sj.RetrieveIntakeCheckpoints(intake)
+	if err != nil || len(offsets) != 0 {
+		t.Errorf("checkpoints should be cleared when done - not %v (err=%v)\n", offsets, err)
+	}
+}
+
+func TestSourceIntake(t *testing.T) {
+	sj.ClearAllStaging()
+	typeName := "person"
+	checkpoint := "test-source"
+	defer sj.ClearIntakeCheckpoints(checkpoint, typeName)
+
+	pages := map[string][]string{
+		"":      {"per0000001", "per0000002"},
+		"page2": {"per0000003"},
+		"page3": {"per0000004"},
+	}
+	next := map[string]string{"": "page2", "page2": "page3", "page3": ""}
+	called := []string{}
+	broken := true
+	source := sj.TokenSource(func(token string) ([]sj.Storeable, string, error) {
+		called = append(called, token)
+		if token == "page3" && broken {
+			return nil, "", errors.New("source unavailable")
+		}
+		list := []sj.Storeable{}
+		for _, id := range pages[token] {
+			list = append(list, sj.MakePacket(id, typeName, IntakePerson{Id: id, Name: "Test"}))
+		}
+		return list, next[token], nil
+	})
+	intake := sj.IntakeConfig{TypeName: typeName, Source: source, Checkpoint: checkpoint}
+
+	err := sj.IntakeInChunks(intake)
+	chunksErr, ok := err.(sj.IntakeChunksError)
+	if !ok || chunksErr.Failed[0].Token != "page3" {
+		t.Errorf("page3 should fail - not %v\n", err)
+	}
+	list, err := sj.RetrieveTypeStaging(typeName)
+	if err != nil || len(list) != 3 {
+		t.Errorf("first 2 pages should be staged - not %d (err=%v)\n", len(list), err)
+	}
+
+	broken = false
+	called = []string{}
+	err = sj.IntakeInChunks(intake)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(called) != 1 || called[0] != "page3" {
+		t.Errorf("rerun should start at page3 - not %v\n", called)
+	}
+	list, err = sj.RetrieveTypeStaging(typeName)
+	if err != nil || len(list) != 4 {
+		t.Errorf("should have staged all 4 - not %d (err=%v)\n", len(list), err)
+	}
+
+	// NOTE: no total - stops at the short page
+	sj.ClearAllStaging()
+	offsets := []int{}
+	offsetSource := sj.OffsetSource{PageSize: 2, ListMaker: func(offset int) ([]sj.Storeable, error) {
+		offsets = append(offsets, offset)
+		list := []sj.Storeable{}
+		for i := offset; i < offset+2 && i < 5; i++ {
+			id := fmt.Sprintf("per%07d", i)
+			list = append(list, sj.MakePacket(id, typeName, IntakePerson{Id: id, Name: "Test"}))
+		}
+		return list, nil
+	}}
+	err = sj.IntakeInChunks(sj.IntakeConfig{TypeName: typeName, Source: offsetSource})
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(offsets) != 3 || offsets[2] != 4 {
+		t.Errorf("should have called with 0, 2, 4 - not %v\n", offsets)
+	}
+}
+
+func TestDeleteThreshold(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+	defer sj.ClearDeleteThresholds()
+
+	list := []sj.Storeable{}
+	for i := 1; i <= 10; i++ {
+		id := fmt.Sprintf("per%07d", i)
+		list = append(list, sj.MakePacket(id, typeName, IntakePerson{Id: id, Name: "Test"}))
+	}
+	err := sj.BulkAddStaging(list...)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	_, err = sj.TransferAll(typeName, func(json string) bool { return true })
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	sj.RegisterDeleteThreshold(typeName, sj.DeleteThreshold{MaxPercent: 20})
+	// NOTE: like a fetch that only got 1 of 10
+	ids := func() ([]string, error) {
+		return []string{"per0000001"}, nil
+	}
+	outake := sj.OutakeConfig{TypeName: typeName, ListMaker: ids}
+	_, err = sj.ProcessOutake(outake)
+	blocked, ok := err.(sj.BlockedDeletesError)
+	if !ok {
+		t.Fatalf("90%% deletes should be blocked - not %v\n", err)
+	}
+	if len(blocked.Deletes) != 9 || blocked.Existing != 10 {
+		t.Errorf("should report 9 of 10 blocked - not %d of %d\n", len(blocked.Deletes), blocked.Existing)
+	}
+	deletes, err := sj.RetrieveDeletedStaging(typeName)
+	if err != nil || len(deletes) != 0 {
+		t.Errorf("nothing should be flagged - not %d (err=%v)\n", len(deletes), err)
+	}
+
+	outake.OverrideToken = "wrong"
+	_, err = sj.ProcessOutake(outake)
+	if _, ok := err.(sj.BlockedDeletesError); !ok {
+		t.Errorf("wrong token should still be blocked - not %v\n", err)
+	}
+	outake.OverrideToken = blocked.Token
+	summary, err := sj.ProcessOutake(outake)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Deleted != 9 {
+		t.Errorf("override should flag 9 - not %s\n", summary)
+	}
+}
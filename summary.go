@@ -0,0 +1,97 @@
+package scramjet
+
+import "fmt"
+
+// what a transfer (or delete) actually did - unchanged means it went
+// through but the hash was the same so resources wasn't touched
+type TransferSummary struct {
+	Inserted  int
+	Updated   int
+	Unchanged int
+	Deleted   int
+	// NOTE: only filled in with Config.SummaryIds (could be big lists)
+	InsertedIds  []Identifier
+	UpdatedIds   []Identifier
+	UnchangedIds []Identifier
+	DeletedIds   []Identifier
+}
+
+// everything except unchanged
+func (s TransferSummary) Changed() int {
+	return s.Inserted + s.Updated + s.Deleted
+}
+
+func (s *TransferSummary) Merge(other TransferSummary) {
+	s.Inserted += other.Inserted
+	s.Updated += other.Updated
+	s.Unchanged += other.Unchanged
+	s.Deleted += other.Deleted
+	s.InsertedIds = append(s.InsertedIds, other.InsertedIds...)
+	s.UpdatedIds = append(s.UpdatedIds, other.UpdatedIds...)
+	s.UnchangedIds = append(s.UnchangedIds, other.UnchangedIds...)
+	s.DeletedIds = append(s.DeletedIds, other.DeletedIds...)
+}
+
+func (s TransferSummary) String() string {
+	return fmt.Sprintf("inserted=%d updated=%d unchanged=%d deleted=%d",
+		s.Inserted, s.Updated, s.Unchanged, s.Deleted)
+}
+
+func summaryIdsEnabled() bool {
+	conf := GetConfig()
+	return conf != nil && conf.SummaryIds
+}
+
+// changes only has what the upsert touched (see moveStagingItemsToResources)
+// so whatever else was sent in is unchanged
+func summarizeTransfer(items []StagingResource, changes []Change) TransferSummary {
+	summary := summarizeChanges(changes)
+	summary.Unchanged = len(items) - summary.Inserted - summary.Updated
+	if summaryIdsEnabled() {
+		changed := make(map[Identifier]bool)
+		for _, change := range changes {
+			changed[change.Id] = true
+		}
+		for _, item := range items {
+			if !changed[item.Identifier()] {
+				summary.UnchangedIds = append(summary.UnchangedIds, item.Identifier())
+			}
+		}
+	}
+	return summary
+}
+
+func summarizeChanges(changes []Change) TransferSummary {
+	summary := TransferSummary{}
+	withIds := summaryIdsEnabled()
+	for _, change := range changes {
+		switch change.Op {
+		case AddOp:
+			summary.Inserted++
+			if withIds {
+				summary.InsertedIds = append(summary.InsertedIds, change.Id)
+			}
+		case UpdateOp:
+			summary.Updated++
+			if withIds {
+				summary.UpdatedIds = append(summary.UpdatedIds, change.Id)
+			}
+		case DeleteOp:
+			summary.Deleted++
+			if withIds {
+				summary.DeletedIds = append(summary.DeletedIds, change.Id)
+			}
+		}
+	}
+	return summary
+}
+
+func summarizeDeletes(deletes []Identifiable) TransferSummary {
+	summary := TransferSummary{Deleted: len(deletes)}
+	if summaryIdsEnabled() {
+		for _, item := range deletes {
+			summary.DeletedIds = append(summary.DeletedIds, item.Identifier())
+		}
+	}
+	return summary
+}
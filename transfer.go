@@ -0,0 +1,231 @@
+package scramjet
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// how Traject gets valid staging rows into resources
+type TransferMode int
+
+const (
+	// validate, move and clear staging as separate steps
+	TransferStandard TransferMode = iota
+	// move and clear staging in one transaction - only the rows moved
+	// (same id, type and data hash) are cleared, so anything staged in
+	// the meantime waits for the next run
+	TransferAtomic
+	// like TransferAtomic but hashing and upserting happen all in
+	// postgres (INSERT ... SELECT) - the json never comes back to go
+	// (unless there are sinks that need it)
+	TransferServerSide
+)
+
+func transferAtomic(typeName string, filter *Filter, validator ValidatorFunc) (TransferSummary, error) {
+	validator = registeredValidator(typeName, validator)
+	// NOTE: no validator (and no collection) - same as dry run, nothing
+	// is rejected
+	if validator == nil {
+		validator = func(json string) bool { return true }
+	}
+	var err error
+	if filter != nil {
+		err = ProcessTypeStagingFiltered(typeName, *filter, validator)
+	} else {
+		err = ProcessTypeStaging(typeName, validator)
+	}
+	if err != nil {
+		return TransferSummary{}, err
+	}
+
+	db := GetPool()
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return TransferSummary{}, errors.Wrap(err, "starting transaction")
+	}
+	// supposedly no-op if everything okay
+	defer tx.Rollback(ctx)
+
+	filterSql := ""
+	if filter != nil {
+		filterSql = "AND " + buildStagingFilterSql(*filter)
+	}
+	sql := fmt.Sprintf(`SELECT id, type, data
+	  FROM staging
+	  WHERE type = $1
+	  AND is_valid = TRUE
+	  %s
+	`, filterSql)
+	rows, err := tx.Query(ctx, sql, typeName)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	staged, err := ScanStaging(rows)
+	rows.Close()
+	if err != nil {
+		return TransferSummary{}, err
+	}
+
+	// NOTE: could have changed since ProcessTypeStaging looked at it
+	items := make([]StagingResource, 0)
+	for _, item := range staged {
+		if validator(string(item.Data)) {
+			items = append(items, item)
+		}
+	}
+
+	changes, table, err := upsertResources(ctx, tx, items)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+
+	sqlClear := fmt.Sprintf(`DELETE FROM staging s
+	  USING %s t
+	  WHERE s.id = t.id
+	  AND s.type = t.type
+	  AND md5(s.data::text) = t.staged_hash
+	`, table)
+	_, err = tx.Exec(ctx, sqlClear)
+	if err != nil {
+		return TransferSummary{}, errors.Wrap(err, "clearing staging table")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return TransferSummary{}, errors.Wrap(err, "commit transaction")
+	}
+	deliverChanges(changes)
+
+	summary := summarizeTransfer(items, changes)
+	GetLogger().Debug(fmt.Sprintf("> transferred %s (atomic): %s\n", typeName, summary))
+	return summary, nil
+}
+
+// NOTE: md5(data::text) is the same as makeHash on the staging data, since
+// the json column keeps the text exactly as it was sent
+// with a validator the rows still have to come to go to be validated
+// (see ProcessTypeStaging), without one everything not marked for delete
+// is moved
+// NOTE: falls back to TransferAtomic for types with HashOptions (the
+// hash has to be made in go)
+func transferServerSide(typeName string, filter *Filter, validator ValidatorFunc) (TransferSummary, error) {
+	validator = registeredValidator(typeName, validator)
+	if !GetHashOptions(typeName).isDefault() {
+		return transferAtomic(typeName, filter, validator)
+	}
+	// NOTE: reference checks happen along with validating
+	if validator == nil && len(enforcedReferences(typeName)) > 0 {
+		validator = func(json string) bool { return true }
+	}
+	var err error
+	validSql := "AND to_delete IS NOT TRUE"
+	if validator != nil {
+		validSql = "AND is_valid = TRUE"
+		if filter != nil {
+			err = ProcessTypeStagingFiltered(typeName, *filter, validator)
+		} else {
+			err = ProcessTypeStaging(typeName, validator)
+		}
+		if err != nil {
+			return TransferSummary{}, err
+		}
+	}
+	filterSql := ""
+	if filter != nil {
+		filterSql = "AND " + buildStagingFilterSql(*filter)
+	}
+
+	db := GetPool()
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return TransferSummary{}, errors.Wrap(err, "starting transaction")
+	}
+	// supposedly no-op if everything okay
+	defer tx.Rollback(ctx)
+
+	table := fmt.Sprintf("resource_data_%s", TimestampString())
+	sqlTemp := fmt.Sprintf(`CREATE TEMPORARY TABLE %s
+	  ON COMMIT DROP
+	  AS SELECT id, type, md5(data::text) AS hash, md5(data::text) AS staged_hash,
+	    data, data::jsonb AS data_b
+	  FROM staging
+	  WHERE type = $1
+	  %s
+	  %s
+	`, table, validSql, filterSql)
+	tag, err := tx.Exec(ctx, sqlTemp, typeName)
+	if err != nil {
+		return TransferSummary{}, errors.Wrap(err, "creating temporary table")
+	}
+	moved := int(tag.RowsAffected())
+
+	changes, err := upsertFromTable(ctx, tx, table, hasSinks() || patchesEnabled())
+	if err != nil {
+		return TransferSummary{}, err
+	}
+	err = writeOutbox(ctx, tx, changes)
+	if err != nil {
+		return TransferSummary{}, err
+	}
+
+	// NOTE: md5 is checked again against the row as it is now
+	// so anything staged since stays for the next run
+	sqlClear := fmt.Sprintf(`DELETE FROM staging s
+	  USING %s t
+	  WHERE s.id = t.id
+	  AND s.type = t.type
+	  AND md5(s.data::text) = t.staged_hash
+	`, table)
+	_, err = tx.Exec(ctx, sqlClear)
+	if err != nil {
+		return TransferSummary{}, errors.Wrap(err, "clearing staging table")
+	}
+
+	summary := summarizeChanges(changes)
+	summary.Unchanged = moved - summary.Inserted - summary.Updated
+	if summaryIdsEnabled() {
+		summary.UnchangedIds, err = unchangedIds(ctx, tx, table, changes)
+		if err != nil {
+			return TransferSummary{}, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return TransferSummary{}, errors.Wrap(err, "commit transaction")
+	}
+	deliverChanges(changes)
+
+	GetLogger().Debug(fmt.Sprintf("> transferred %s (server side): %s\n", typeName, summary))
+	return summary, nil
+}
+
+// what was in table but didn't change
+func unchangedIds(ctx context.Context, tx pgx.Tx, table string, changes []Change) ([]Identifier, error) {
+	changed := make(map[Identifier]bool)
+	for _, change := range changes {
+		changed[change.Id] = true
+	}
+	rows, err := tx.Query(ctx, fmt.Sprintf(`SELECT id, type FROM %s`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []Identifier{}
+	for rows.Next() {
+		var id Identifier
+		err = rows.Scan(&id.Id, &id.Type)
+		if err != nil {
+			return ids, errors.Wrap(err, "cannot scan in identifier")
+		}
+		if !changed[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
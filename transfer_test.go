@@ -0,0 +1,114 @@
+package scramjet_test
+
+import (
+	"strings"
+	"testing"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+func TestAtomicTransfer(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+
+	person1 := TestPerson{Id: "per0000001", Name: "Test1"}
+	person2 := TestPerson{Id: "per0000002", Name: "Test2"}
+	person3 := TestPerson{Id: "per0000003", Name: "Bad"}
+	err := sj.StashStaging(sj.MakePacket(person1.Id, typeName, person1),
+		sj.MakePacket(person2.Id, typeName, person2),
+		sj.MakePacket(person3.Id, typeName, person3))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	notBad := func(json string) bool { return !strings.Contains(json, "Bad") }
+	move := sj.TrajectConfig{TypeName: typeName, Validator: notBad, Mode: sj.TransferAtomic}
+	summary, err := sj.Traject(move)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Inserted != 2 {
+		t.Errorf("should have inserted 2 - not %s\n", summary)
+	}
+	count := sj.ResourceCount(typeName)
+	if count != 2 {
+		t.Errorf("should be 2 records - not %d\n", count)
+	}
+	// only the one that didn't validate is left
+	staged, _ := sj.RetrieveTypeStaging(typeName)
+	if len(staged) != 1 || staged[0].Id != person3.Id {
+		t.Errorf("only %s should be left in staging - not %v\n", person3.Id, staged)
+	}
+}
+
+func TestAtomicTransferWithoutValidator(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	sj.ClearCollections()
+	typeName := "person"
+
+	person1 := TestPerson{Id: "per0000001", Name: "Test1"}
+	err := sj.StashStaging(sj.MakePacket(person1.Id, typeName, person1))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	// NOTE: no validator and no collection - nothing is rejected
+	move := sj.TrajectConfig{TypeName: typeName, Mode: sj.TransferAtomic}
+	summary, err := sj.Traject(move)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Inserted != 1 {
+		t.Errorf("should have inserted 1 - not %s\n", summary)
+	}
+}
+
+func TestServerSideTransferSameHash(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearAllResources()
+	typeName := "person"
+	alwaysOkay := func(json string) bool { return true }
+
+	person1 := TestPerson{Id: "per0000001", Name: "Test1"}
+	person2 := TestPerson{Id: "per0000002", Name: "Test2"}
+	stash := func() {
+		err := sj.StashStaging(sj.MakePacket(person1.Id, typeName, person1),
+			sj.MakePacket(person2.Id, typeName, person2))
+		if err != nil {
+			t.Errorf("err=%v\n", err)
+		}
+	}
+
+	// first the usual way
+	stash()
+	_, err := sj.TransferAll(typeName, alwaysOkay)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	// same data server side should not look changed
+	stash()
+	move := sj.TrajectConfig{TypeName: typeName, Mode: sj.TransferServerSide}
+	summary, err := sj.Traject(move)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Unchanged != 2 || summary.Changed() != 0 {
+		t.Errorf("should be 2 unchanged - not %s\n", summary)
+	}
+
+	person2.Name = "Test2updated"
+	stash()
+	move.Validator = alwaysOkay
+	summary, err = sj.Traject(move)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if summary.Updated != 1 || summary.Unchanged != 1 {
+		t.Errorf("should be 1 updated, 1 unchanged - not %s\n", summary)
+	}
+	if sj.StagingCount() != 0 {
+		t.Errorf("staging should be empty - not %d\n", sj.StagingCount())
+	}
+}
@@ -0,0 +1,161 @@
+package scramjet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// changes a record (decoded json - numbers are json.Number) on the way
+// into staging - returning nil drops it (without an error)
+type TransformFunc func(record map[string]interface{}) (map[string]interface{}, error)
+
+type namedTransform struct {
+	name      string
+	transform TransformFunc
+}
+
+var transformMutex sync.RWMutex
+var transforms = make(map[string][]namedTransform)
+
+// run in the order registered - registering a name again replaces it
+// (in the same place)
+func RegisterTransform(typeName string, name string, transform TransformFunc) {
+	transformMutex.Lock()
+	defer transformMutex.Unlock()
+	for i, existing := range transforms[typeName] {
+		if existing.name == name {
+			transforms[typeName][i].transform = transform
+			return
+		}
+	}
+	transforms[typeName] = append(transforms[typeName],
+		namedTransform{name: name, transform: transform})
+}
+
+func ClearTransforms() {
+	transformMutex.Lock()
+	defer transformMutex.Unlock()
+	transforms = make(map[string][]namedTransform)
+}
+
+// names of the transforms for the type, in order
+func GetTransforms(typeName string) []string {
+	transformMutex.RLock()
+	defer transformMutex.RUnlock()
+	names := []string{}
+	for _, transform := range transforms[typeName] {
+		names = append(names, transform.name)
+	}
+	return names
+}
+
+func typeTransforms(typeName string) []namedTransform {
+	transformMutex.RLock()
+	defer transformMutex.RUnlock()
+	return append([]namedTransform{}, transforms[typeName]...)
+}
+
+// what StageTransformed did with the items - Staged doesn't count the
+// dropped, failed or missing ones
+type StageResult struct {
+	Staged  int
+	Dropped int           // a transform returned nil
+	Failed  []IntakeError // in intake_errors now
+	Missing []MissingId   // same as the MissingIdError
+}
+
+// stages items (like BulkAddStaging) after running each through the
+// transforms for its type - a record that fails one is not staged, and
+// is saved in intake_errors instead (see RetrieveIntakeErrors)
+// NOTE: with a MissingIdError the rest were still staged (and counted)
+func StageTransformed(items ...Storeable) (StageResult, error) {
+	transformed, positions, failed := applyTransforms(items)
+	result := StageResult{Dropped: len(items) - len(transformed) - len(failed),
+		Failed: failed, Missing: []MissingId{}}
+	if len(failed) > 0 {
+		GetLogger().Info(fmt.Sprintf("> %d records failed transforms\n", len(failed)))
+		err := recordIntakeErrors(failed)
+		if err != nil {
+			return result, err
+		}
+	}
+	err := BulkAddStaging(transformed...)
+	if missing, ok := err.(MissingIdError); ok {
+		// NOTE: so Index is the position in items
+		for i := range missing.Records {
+			missing.Records[i].Index = positions[missing.Records[i].Index]
+		}
+		result.Missing = missing.Records
+		result.Staged = len(transformed) - len(missing.Records)
+		return result, err
+	}
+	if err != nil {
+		return result, err
+	}
+	result.Staged = len(transformed)
+	return result, nil
+}
+
+// also where each of the transformed came from in items
+func applyTransforms(items []Storeable) ([]Storeable, []int, []IntakeError) {
+	transformed := make([]Storeable, 0, len(items))
+	positions := make([]int, 0, len(items))
+	failed := make([]IntakeError, 0)
+	for i, item := range items {
+		id := item.Identifier()
+		chain := typeTransforms(id.Type)
+		if len(chain) == 0 {
+			transformed = append(transformed, item)
+			positions = append(positions, i)
+			continue
+		}
+		data, err := json.Marshal(item.Object())
+		if err != nil {
+			failed = append(failed, IntakeError{Id: id, Reason: err.Error()})
+			continue
+		}
+		record, name, err := transformRecord(data, chain)
+		if err != nil {
+			failed = append(failed, IntakeError{Id: id, Transform: name, Reason: err.Error(),
+				Data: json.RawMessage(data)})
+			continue
+		}
+		if record == nil {
+			continue
+		}
+		// NOTE: no id yet is fine - it can come from the transformed data
+		if len(id.Id) == 0 {
+			transformed = append(transformed, MakeRawPacket(id.Type, record))
+		} else {
+			transformed = append(transformed, Packet{Id: id, Obj: record})
+		}
+		positions = append(positions, i)
+	}
+	return transformed, positions, failed
+}
+
+// the record after the chain (nil if dropped), or the name of the one
+// that failed
+func transformRecord(data []byte, chain []namedTransform) (map[string]interface{}, string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var record map[string]interface{}
+	err := decoder.Decode(&record)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "record is not a json object")
+	}
+	for _, transform := range chain {
+		record, err = transform.transform(record)
+		if err != nil {
+			return nil, transform.name, err
+		}
+		if record == nil {
+			return nil, "", nil
+		}
+	}
+	return record, "", nil
+}
@@ -0,0 +1,87 @@
+package scramjet_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+func TestTransforms(t *testing.T) {
+	sj.ClearAllStaging()
+	sj.ClearIntakeErrors("")
+	defer sj.ClearTransforms()
+
+	sj.RegisterTransform("person", "trim", func(record map[string]interface{}) (map[string]interface{}, error) {
+		if name, ok := record["name"].(string); ok {
+			record["name"] = strings.TrimSpace(name)
+		}
+		return record, nil
+	})
+	sj.RegisterTransform("person", "internal", func(record map[string]interface{}) (map[string]interface{}, error) {
+		delete(record, "internal")
+		return record, nil
+	})
+	sj.RegisterTransform("person", "required", func(record map[string]interface{}) (map[string]interface{}, error) {
+		if record["name"] == "" {
+			return nil, errors.New("no name")
+		}
+		return record, nil
+	})
+	if names := sj.GetTransforms("person"); len(names) != 3 || names[0] != "trim" {
+		t.Errorf("transforms should be in order - not %v\n", names)
+	}
+
+	listMaker := func(i int) ([]sj.Storeable, error) {
+		return []sj.Storeable{
+			sj.MakePacket("per0000001", "person", map[string]string{"id": "per0000001",
+				"name": " Test1 ", "internal": "x"}),
+			sj.MakePacket("per0000002", "person", map[string]string{"id": "per0000002",
+				"name": "  "}),
+		}, nil
+	}
+	err := sj.IntakeInChunks(sj.IntakeConfig{TypeName: "person", ListMaker: listMaker})
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+
+	staged, err := sj.RetrieveSingleStaging("per0000001", "person")
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if string(staged.Data) != `{"id":"per0000001","name":"Test1"}` {
+		t.Errorf("should be trimmed without internal - not %s\n", staged.Data)
+	}
+	list, err := sj.RetrieveTypeStaging("person")
+	if err != nil || len(list) != 1 {
+		t.Errorf("only per0000001 should be staged - not %v (err=%v)\n", list, err)
+	}
+
+	sj.ClearAllStaging()
+	sj.RegisterTransform("person", "drop", func(record map[string]interface{}) (map[string]interface{}, error) {
+		if record["id"] == "per0000003" {
+			return nil, nil
+		}
+		return record, nil
+	})
+	result, err := sj.StageTransformed(
+		sj.MakePacket("per0000001", "person", map[string]string{"id": "per0000001", "name": "Test1"}),
+		sj.MakePacket("per0000002", "person", map[string]string{"id": "per0000002", "name": ""}),
+		sj.MakePacket("per0000003", "person", map[string]string{"id": "per0000003", "name": "Test3"}),
+	)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if result.Staged != 1 || result.Dropped != 1 || len(result.Failed) != 1 {
+		t.Errorf("should be 1 staged, 1 dropped, 1 failed - not %+v\n", result)
+	}
+
+	failed, err := sj.RetrieveIntakeErrors("person")
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if len(failed) != 2 || failed[0].Id.Id != "per0000002" || failed[0].Transform != "required" {
+		t.Errorf("per0000002 should have failed required (twice) - not %v\n", failed)
+	}
+}
@@ -0,0 +1,249 @@
+package scramjet
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const WebhookSignatureHeader = "X-Scramjet-Signature"
+const WebhookEventHeader = "X-Scramjet-Event"
+
+// what gets POSTed - one per type and operation
+type WebhookPayload struct {
+	Type      string          `json:"type"`
+	Operation ChangeOp        `json:"operation"`
+	Ids       []string        `json:"ids"`
+	Changes   []WebhookChange `json:"changes"`
+}
+
+type WebhookChange struct {
+	Id    string          `json:"id"`
+	Hash  string          `json:"hash"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Patch JSONPatch       `json:"patch,omitempty"`
+}
+
+// POSTs change batches to each url, signed with hmac-sha256 of the body
+// NOTE: after MaxAttempts (or once RetryWithin is used up) the payload is
+// kept in webhook_dead_letters (see ReplayWebhookDeadLetters) instead of
+// failing the sink
+type WebhookSink struct {
+	SinkName    string
+	Urls        []string
+	Secret      string
+	MaxAttempts int
+	Backoff     time.Duration // wait after first failure, doubles each time
+	// most time one Send waits between retries, all urls together - Send
+	// runs right after a transfer commits (with any type lock still held)
+	// 0 is what the MaxAttempts backoffs add up to, plus webhookRequestTime
+	RetryWithin time.Duration
+	Client      *http.Client
+}
+
+const defaultWebhookBackoff = time.Second
+
+// for the requests themselves, on top of the backoffs
+const webhookRequestTime = 5 * time.Second
+
+func MakeWebhookSink(name string, secret string, urls ...string) *WebhookSink {
+	return &WebhookSink{
+		SinkName:    name,
+		Urls:        urls,
+		Secret:      secret,
+		MaxAttempts: 5,
+		Backoff:     defaultWebhookBackoff,
+		Client:      &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *WebhookSink) Name() string {
+	return s.SinkName
+}
+
+func (s *WebhookSink) maxAttempts() int {
+	if s.MaxAttempts <= 0 {
+		return 1
+	}
+	return s.MaxAttempts
+}
+
+func (s *WebhookSink) backoff() time.Duration {
+	if s.Backoff <= 0 {
+		return defaultWebhookBackoff
+	}
+	return s.Backoff
+}
+
+func (s *WebhookSink) retryDeadline() time.Time {
+	retryWithin := s.RetryWithin
+	if retryWithin <= 0 {
+		// NOTE: waits are backoff, 2x, 4x ... - so 2^(n-1) - 1 backoffs
+		doublings := s.maxAttempts() - 1
+		if doublings > 20 {
+			doublings = 20
+		}
+		retryWithin = s.backoff()*time.Duration((1<<doublings)-1) + webhookRequestTime
+	}
+	return time.Now().Add(retryWithin)
+}
+
+func (s *WebhookSink) Send(batch ChangeBatch) error {
+	deadline := s.retryDeadline()
+	for _, payload := range MakeWebhookPayloads(batch) {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return errors.Wrap(err, "making webhook payload")
+		}
+		for _, url := range s.Urls {
+			attempts, err := s.deliver(url, payload.event(), body, deadline)
+			if err == nil {
+				continue
+			}
+			GetLogger().Info(fmt.Sprintf("webhook %s failed after %d attempts: %s\n", url, attempts, err))
+			recordErr := RecordWebhookDeadLetter(WebhookDeadLetter{
+				Sink:      s.SinkName,
+				Url:       url,
+				Type:      payload.Type,
+				Operation: payload.Operation,
+				Payload:   body,
+				Attempts:  attempts,
+				LastError: err.Error(),
+			})
+			if recordErr != nil {
+				return errors.Wrap(recordErr, fmt.Sprintf("webhook %s failed (%s) and could not be recorded", url, err))
+			}
+		}
+	}
+	return nil
+}
+
+// one payload per operation that has changes
+func MakeWebhookPayloads(batch ChangeBatch) []WebhookPayload {
+	payloads := make([]WebhookPayload, 0)
+	for _, group := range []struct {
+		op      ChangeOp
+		changes []Change
+	}{{AddOp, batch.Adds}, {UpdateOp, batch.Updates}, {DeleteOp, batch.Deletes}} {
+		if len(group.changes) == 0 {
+			continue
+		}
+		payload := WebhookPayload{Type: batch.TypeName, Operation: group.op,
+			Ids: []string{}, Changes: []WebhookChange{}}
+		for _, change := range group.changes {
+			hash := change.Hash
+			if group.op == DeleteOp {
+				hash = change.PreviousHash
+			}
+			payload.Ids = append(payload.Ids, change.Id.Id)
+			payload.Changes = append(payload.Changes, WebhookChange{
+				Id:    change.Id.Id,
+				Hash:  hash,
+				Data:  json.RawMessage(change.Data),
+				Patch: change.Patch,
+			})
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+func (p WebhookPayload) event() string {
+	return fmt.Sprintf("%s.%s", p.Type, p.Operation)
+}
+
+// value of the signature header e.g. "sha256=4f2a..."
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// for the receiving end
+func VerifyWebhookSignature(secret string, body []byte, signature string) bool {
+	expected := SignWebhookPayload(secret, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// tries up to MaxAttempts times (without waiting past deadline), returns
+// how many it took
+func (s *WebhookSink) deliver(url string, event string, body []byte, deadline time.Time) (int, error) {
+	maxAttempts := s.maxAttempts()
+	wait := s.backoff()
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = s.post(url, event, body)
+		if err == nil {
+			return attempt, nil
+		}
+		if attempt == maxAttempts || time.Now().Add(wait).After(deadline) {
+			return attempt, err
+		}
+		time.Sleep(wait)
+		wait = wait * 2
+	}
+	return maxAttempts, err
+}
+
+func (s *WebhookSink) post(url string, event string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event)
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(s.Secret, body))
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("webhook returned %d: %s", resp.StatusCode, msg))
+	}
+	return nil
+}
+
+// sends the dead letters of this sink again (to the url they were
+// meant for) - the ones that go through are removed
+func ReplayWebhookDeadLetters(sink *WebhookSink) (int, error) {
+	letters, err := RetrieveWebhookDeadLetters(sink.SinkName)
+	if err != nil {
+		return 0, err
+	}
+	replayed := 0
+	var lastErr error
+	for _, letter := range letters {
+		event := fmt.Sprintf("%s.%s", letter.Type, letter.Operation)
+		// NOTE: each gets its own RetryWithin (nothing is waiting on a replay)
+		attempts, err := sink.deliver(letter.Url, event, letter.Payload, sink.retryDeadline())
+		if err != nil {
+			lastErr = errors.Wrap(err, fmt.Sprintf("replaying dead letter %d", letter.Id))
+			updateErr := UpdateWebhookDeadLetter(letter.Id, attempts, err)
+			if updateErr != nil {
+				return replayed, updateErr
+			}
+			continue
+		}
+		err = DeleteWebhookDeadLetter(letter.Id)
+		if err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	return replayed, lastErr
+}
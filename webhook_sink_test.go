@@ -0,0 +1,156 @@
+package scramjet_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sj "github.com/OIT-ADS-Web/scramjet"
+)
+
+type webhookStandIn struct {
+	secret   string
+	failures int // how many requests fail before working
+	calls    int
+	payloads []sj.WebhookPayload
+	t        *testing.T
+}
+
+func (h *webhookStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.calls++
+	body, _ := ioutil.ReadAll(r.Body)
+	if !sj.VerifyWebhookSignature(h.secret, body, r.Header.Get(sj.WebhookSignatureHeader)) {
+		h.t.Errorf("bad webhook signature %s\n", r.Header.Get(sj.WebhookSignatureHeader))
+	}
+	if h.failures > 0 {
+		h.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var payload sj.WebhookPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		h.t.Errorf("bad webhook payload %s\n", body)
+	}
+	h.payloads = append(h.payloads, payload)
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestWebhookSinkRetries(t *testing.T) {
+	handler := &webhookStandIn{secret: "s3cret", failures: 2, t: t}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	sink := sj.MakeWebhookSink("hook", "s3cret", server.URL)
+	sink.MaxAttempts = 3
+	sink.Backoff = time.Millisecond
+
+	batch := sj.MakeChangeBatch("person",
+		sj.Change{Id: sj.Identifier{Id: "per0000001", Type: "person"}, Op: sj.AddOp,
+			Hash: "abc", Data: []byte(`{"id": "per0000001", "name": "Test1"}`)})
+	err := sink.Send(batch)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if handler.calls != 3 {
+		t.Errorf("should have taken 3 attempts - not %d\n", handler.calls)
+	}
+	if len(handler.payloads) != 1 {
+		t.Fatalf("should have received 1 payload - not %d\n", len(handler.payloads))
+	}
+	payload := handler.payloads[0]
+	if payload.Operation != sj.AddOp || payload.Ids[0] != "per0000001" || payload.Changes[0].Hash != "abc" {
+		t.Errorf("unexpected payload %v\n", payload)
+	}
+}
+
+func TestWebhookDeadLetterReplay(t *testing.T) {
+	handler := &webhookStandIn{secret: "s3cret", failures: 2, t: t}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	sink := sj.MakeWebhookSink("hook-dead", "s3cret", server.URL)
+	sink.MaxAttempts = 2
+	sink.Backoff = time.Millisecond
+	sj.ClearWebhookDeadLetters(sink.Name())
+
+	batch := sj.MakeChangeBatch("person",
+		sj.Change{Id: sj.Identifier{Id: "per0000001", Type: "person"}, Op: sj.DeleteOp,
+			PreviousHash: "abc"})
+	err := sink.Send(batch)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	letters, _ := sj.RetrieveWebhookDeadLetters(sink.Name())
+	if len(letters) != 1 {
+		t.Fatalf("should be 1 dead letter - not %d\n", len(letters))
+	}
+
+	replayed, err := sj.ReplayWebhookDeadLetters(sink)
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if replayed != 1 || len(handler.payloads) != 1 {
+		t.Errorf("should have replayed 1 dead letter - not %d\n", replayed)
+	}
+	letters, _ = sj.RetrieveWebhookDeadLetters(sink.Name())
+	if len(letters) != 0 {
+		t.Errorf("dead letters should be gone after replay - found %d\n", len(letters))
+	}
+}
+
+func TestWebhookDefaultRetryWithin(t *testing.T) {
+	handler := &webhookStandIn{secret: "s3cret", failures: 10, t: t}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// NOTE: no RetryWithin - it's made big enough for every attempt
+	sink := &sj.WebhookSink{SinkName: "hook-default", Secret: "s3cret",
+		Urls: []string{server.URL}, MaxAttempts: 4, Backoff: time.Millisecond}
+	sj.ClearWebhookDeadLetters(sink.Name())
+	defer sj.ClearWebhookDeadLetters(sink.Name())
+
+	err := sink.Send(sj.MakeChangeBatch("person",
+		sj.Change{Id: sj.Identifier{Id: "per0000001", Type: "person"}, Op: sj.AddOp}))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	letters, _ := sj.RetrieveWebhookDeadLetters(sink.Name())
+	if handler.calls != 4 || len(letters) != 1 || letters[0].Attempts != 4 {
+		t.Errorf("should be 1 dead letter after 4 attempts - not %d calls %v\n", handler.calls, letters)
+	}
+}
+
+func TestWebhookRetryWithin(t *testing.T) {
+	handler := &webhookStandIn{secret: "s3cret", failures: 10, t: t}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// NOTE: the first backoff is already past RetryWithin - so no waiting
+	sink := sj.MakeWebhookSink("hook-slow", "s3cret", server.URL)
+	sink.MaxAttempts = 5
+	sink.Backoff = time.Minute
+	sink.RetryWithin = 10 * time.Millisecond
+	sj.ClearWebhookDeadLetters(sink.Name())
+	defer sj.ClearWebhookDeadLetters(sink.Name())
+
+	start := time.Now()
+	err := sink.Send(sj.MakeChangeBatch("person",
+		sj.Change{Id: sj.Identifier{Id: "per0000001", Type: "person"}, Op: sj.AddOp}))
+	if err != nil {
+		t.Errorf("err=%v\n", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Send should not wait out the backoff - took %s\n", time.Since(start))
+	}
+	if handler.calls != 1 {
+		t.Errorf("should have tried once - not %d\n", handler.calls)
+	}
+	letters, _ := sj.RetrieveWebhookDeadLetters(sink.Name())
+	if len(letters) != 1 || letters[0].Attempts != 1 {
+		t.Errorf("should be 1 dead letter after 1 attempt - not %v\n", letters)
+	}
+}